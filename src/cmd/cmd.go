@@ -1,22 +1,26 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/nuwa/bpp.v3/console"
+	"github.com/nuwa/bpp.v3/engine"
 	"github.com/nuwa/bpp.v3/environment"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"os"
+	"strconv"
 )
 
 func Command() []*cobra.Command {
+	var releaseDryRun bool
 	var releaseCmd = &cobra.Command{
 		Use:     "release",
 		Short:   "Kubernetes Release Config",
-		Example: "release",
+		Example: "release [--dry-run]",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := console.KubernetesRelease()
+			err := console.KubernetesRelease(releaseDryRun)
 			if err != nil {
 				color.Red(fmt.Sprint(err))
 				os.Exit(1)
@@ -24,18 +28,225 @@ func Command() []*cobra.Command {
 		},
 	}
 
+	releaseCmd.Flags().BoolVar(&releaseDryRun, "dry-run", false, "Server side dry run, print the planned change only")
+	releaseCmd.AddCommand(&cobra.Command{
+		Use:   "promote",
+		Short: "Promote the canary to the main Deployment",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesCanaryPromote()
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	})
+	releaseCmd.AddCommand(&cobra.Command{
+		Use:   "abort",
+		Short: "Abort the canary and delete the canary Deployment",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesCanaryAbort()
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	})
+
+	releaseCmd.AddCommand(&cobra.Command{
+		Use:     "history",
+		Short:   "List release history of the service",
+		Example: "release history [service]",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesReleaseHistory(lo.IfF(len(args) > 0, func() string { return args[0] }).Else(""))
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	})
+
+	var applyPrune, applyDryRun bool
+	var applyCmd = &cobra.Command{
+		Use:     "apply",
+		Short:   "Kubernetes Apply Manifests With Server Side Apply",
+		Example: "apply [--prune] [--dry-run]",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesApply(applyPrune, applyDryRun)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+	applyCmd.Flags().BoolVar(&applyPrune, "prune", false, "Delete objects that were removed from the manifests since the last apply")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Server side dry run, print the planned change only")
+
+	var rollbackCmd = &cobra.Command{
+		Use:     "rollback",
+		Short:   "Kubernetes Rollback To Previous (Or Specified) Revision",
+		Example: "rollback [revision]",
+		Run: func(cmd *cobra.Command, args []string) {
+			var revision int64
+			if len(args) > 0 {
+				value, err := strconv.ParseInt(args[0], 10, 64)
+				if err != nil {
+					color.Red(fmt.Sprintf("Revision %s Format Error", args[0]))
+					os.Exit(1)
+				}
+				revision = value
+			}
+			err := console.KubernetesRollback(revision)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	var statusJson bool
+	var statusCmd = &cobra.Command{
+		Use:     "status",
+		Short:   "Kubernetes Service Status Across Colonies",
+		Example: "status [--json]",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesStatus(statusJson)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+	statusCmd.Flags().BoolVar(&statusJson, "json", false, "Print the status as JSON")
+
+	var scaleCmd = &cobra.Command{
+		Use:     "scale",
+		Short:   "Kubernetes Scale Service Replicas",
+		Example: "scale <replicas>",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			replicas, err := strconv.ParseInt(args[0], 10, 32)
+			if err != nil || replicas < 0 {
+				color.Red(fmt.Sprintf("Replicas %s Format Error", args[0]))
+				os.Exit(1)
+			}
+			err = console.KubernetesScale(int32(replicas))
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	var pauseCmd = &cobra.Command{
+		Use:     "pause",
+		Short:   "Kubernetes Pause Service Rollout",
+		Example: "pause",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesPause(true)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	var resumeCmd = &cobra.Command{
+		Use:     "resume",
+		Short:   "Kubernetes Resume Service Rollout",
+		Example: "resume",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesPause(false)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	var logOptions engine.LogOptions
+	var logsCmd = &cobra.Command{
+		Use:     "logs",
+		Short:   "Kubernetes Service Pod Logs Across Colonies",
+		Example: "logs [-c container] [--since 10m] [--tail 100] [-f]",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesLogs(logOptions)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+	logsCmd.Flags().StringVarP(&logOptions.Container, "container", "c", "", "Only print logs of the container")
+	logsCmd.Flags().DurationVar(&logOptions.Since, "since", 0, "Only print logs newer than a relative duration like 5s, 2m, or 3h")
+	logsCmd.Flags().BoolVarP(&logOptions.Follow, "follow", "f", false, "Keep streaming the logs")
+	logsCmd.Flags().Int64Var(&logOptions.Tail, "tail", 100, "Lines of recent log per container, -1 for all")
+
+	var eventsCmd = &cobra.Command{
+		Use:     "events",
+		Short:   "Kubernetes Service Events Across Colonies",
+		Example: "events",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.KubernetesEvents()
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+
+	var nacosSyncPlan, nacosSyncPrune bool
 	var nacosSyncCmd = &cobra.Command{
 		Use:     "nacosSync",
 		Short:   "Nacos Config Sync",
-		Example: "nacosSync",
+		Example: "nacosSync [--plan] [--prune]",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := console.NacosSync()
+			err := console.NacosSync(nacosSyncPlan, nacosSyncPrune)
+			if errors.Is(err, engine.ErrNacosPlanChanges) {
+				// 存在待执行的变更 (区别于执行失败)
+				color.Yellow(fmt.Sprint(err))
+				os.Exit(2)
+			}
 			if err != nil {
 				color.Red(fmt.Sprint(err))
 				os.Exit(1)
 			}
 		},
 	}
+	nacosSyncCmd.Flags().BoolVar(&nacosSyncPlan, "plan", false, "Print the planned changes with a content diff only, exit with code 2 when changes are pending")
+	nacosSyncCmd.Flags().BoolVar(&nacosSyncPrune, "prune", false, "Delete remote configs that have no local file (backed up before deletion)")
+
+	var configSyncRestart, configSyncPrune bool
+	var k8sConfigSyncCmd = &cobra.Command{
+		Use:     "k8sConfigSync",
+		Short:   "Kubernetes ConfigMap And Secret Sync",
+		Example: "k8sConfigSync [--restart] [--prune]",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.K8sConfigSync(configSyncRestart, configSyncPrune)
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	}
+	k8sConfigSyncCmd.Flags().BoolVar(&configSyncRestart, "restart", false, "Rolling restart the service when the config content changes")
+	k8sConfigSyncCmd.Flags().BoolVar(&configSyncPrune, "prune", false, "Delete bpp managed ConfigMaps and Secrets that were removed from the directory")
+
+	var imageCmd = &cobra.Command{
+		Use:     "image",
+		Short:   "Image Name Rewrite Rules",
+		Example: "image resolve [image]",
+	}
+	imageCmd.AddCommand(&cobra.Command{
+		Use:   "resolve",
+		Short: "Show how the image is rewritten for every colony",
+		Run: func(cmd *cobra.Command, args []string) {
+			err := console.ImageResolve(lo.IfF(len(args) > 0, func() string { return args[0] }).Else(""))
+			if err != nil {
+				color.Red(fmt.Sprint(err))
+				os.Exit(1)
+			}
+		},
+	})
 
 	var environmentCmd = &cobra.Command{
 		Use:     "env",
@@ -95,7 +306,17 @@ func Command() []*cobra.Command {
 
 	return []*cobra.Command{
 		releaseCmd,
+		applyCmd,
+		rollbackCmd,
+		statusCmd,
+		scaleCmd,
+		pauseCmd,
+		resumeCmd,
+		logsCmd,
+		eventsCmd,
 		nacosSyncCmd,
+		k8sConfigSyncCmd,
+		imageCmd,
 		environmentCmd,
 	}
 }
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLine 差异行 (' ' 相同, '-' 删除, '+' 新增)
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// splitLines 按行拆分 (保留换行符, 用于识别文件末尾缺少换行)
+func splitLines(content string) []string {
+	var lines = strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines 基于最长公共子序列计算行差异 (先去除相同的前缀与后缀)
+func diffLines(from, to []string) []diffLine {
+	var prefix = 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	var suffix = 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	var a, b = from[prefix : len(from)-suffix], to[prefix : len(to)-suffix]
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	var lcs = make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []diffLine
+	for _, text := range from[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	var i, j = 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i, j = i+1, j+1
+		case j >= len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for _, text := range from[len(from)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+// hunkRange 差异块行号范围 (长度为 0 时行号为前一行)
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// UnifiedDiff 输出两段文本的统一格式差异 (diff -u, contextLines 为上下文行数), 内容相同时返回空
+func UnifiedDiff(fromName, toName, from, to string, contextLines int) string {
+	if from == to {
+		return ""
+	}
+	var lines = diffLines(splitLines(from), splitLines(to))
+	var builder strings.Builder
+	builder.WriteString("--- " + fromName + "\n")
+	builder.WriteString("+++ " + toName + "\n")
+	// 行号: 每行在原文本与新文本中的起始行
+	var fromLine, toLine = make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for index, line := range lines {
+		fromLine[index+1], toLine[index+1] = fromLine[index], toLine[index]
+		if line.kind != '+' {
+			fromLine[index+1]++
+		}
+		if line.kind != '-' {
+			toLine[index+1]++
+		}
+	}
+	for index := 0; index < len(lines); {
+		if lines[index].kind == ' ' {
+			index++
+			continue
+		}
+		// 合并间隔不超过 2 倍上下文的变更
+		var start = index - contextLines
+		if start < 0 {
+			start = 0
+		}
+		var end = index
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			var next = end
+			for next < len(lines) && lines[next].kind == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		var stop = end + contextLines
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		builder.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(fromLine[start], fromLine[stop]-fromLine[start]),
+			hunkRange(toLine[start], toLine[stop]-toLine[start])))
+		for _, line := range lines[start:stop] {
+			builder.WriteByte(line.kind)
+			builder.WriteString(strings.TrimSuffix(line.text, "\n") + "\n")
+			if !strings.HasSuffix(line.text, "\n") {
+				builder.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		index = stop
+	}
+	return builder.String()
+}
@@ -19,40 +19,49 @@ import (
  *  - 驼峰的环境变量 是程序运行的时候入参 也有可能是早期程序运行产生的环境变量
  */
 
-// KubernetesRelease 发布服务.
-func KubernetesRelease() error {
+// kubernetesTarget 读取发布目标: 集群名称、集群环境、命名空间、服务名称.
+func kubernetesTarget() (string, string, string, string, error) {
 	// 读取集群名称
 	colony, ok := environment.Get("P_COLONY")
 	if !ok {
-		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_COLONY"))
+		return "", "", "", "", errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_COLONY"))
 	}
 	colony = strings.ToUpper(colony)
 	// 读取集群环境名称
 	colonyEnv, ok := environment.Get("colonyEnv")
 	if !ok {
-		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "colonyEnv"))
+		return "", "", "", "", errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "colonyEnv"))
 	}
 	// 读取命名空间
 	namespace, ok := environment.Get("P_NAMESPACE_" + strings.ToUpper(colonyEnv))
 	if !ok {
 		namespace, ok = environment.Get("P_NAMESPACE")
 		if !ok {
-			return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_NAMESPACE"))
+			return "", "", "", "", errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_NAMESPACE"))
 		}
 	}
+	// 读取服务名称
+	serviceName, ok := environment.Get("P_SERVICE_NAME")
+	if !ok {
+		return "", "", "", "", errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_SERVICE_NAME"))
+	}
+	return colony, colonyEnv, namespace, serviceName, nil
+}
+
+// KubernetesRelease 发布服务, dryRun 为 true 时仅输出发布计划.
+func KubernetesRelease(dryRun bool) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
 	// 读取镜像名称
 	imageName, ok := environment.Get("P_IMAGE_NAME")
 	if !ok {
 		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_IMAGE_NAME"))
 	}
-	// 读取服务名称
-	serviceName, ok := environment.Get("P_SERVICE_NAME")
-	if !ok {
-		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_SERVICE_NAME"))
-	}
 
 	// 更新服务
-	err := engine.ExecuteReleaseService(colony, colonyEnv, namespace, serviceName, imageName)
+	err = engine.ExecuteReleaseService(colony, colonyEnv, namespace, serviceName, imageName, dryRun)
 	if err != nil {
 		return err
 	}
@@ -60,9 +69,126 @@ func KubernetesRelease() error {
 	return nil
 }
 
-// NacosSync 同步配置.
-func NacosSync() error {
-	// 服务类型
+// KubernetesApply 服务端应用清单目录, prune 为 true 时删除已移除的对象, dryRun 为 true 时仅校验.
+func KubernetesApply(prune, dryRun bool) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	// 清单目录
+	workDirectory, ok := environment.Get("CI_PROJECT_DIR")
+	if !ok {
+		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "CI_PROJECT_DIR"))
+	}
+	manifestDirectory, ok := environment.Get("P_MANIFEST_DIRECTORY")
+	if !ok {
+		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_MANIFEST_DIRECTORY"))
+	}
+	return engine.ExecuteApplyManifests(colony, colonyEnv, namespace, serviceName, path.Join(workDirectory, manifestDirectory), prune, dryRun)
+}
+
+// KubernetesRollback 回滚服务到指定版本 (revision 为 0 时回滚到上一个版本).
+func KubernetesRollback(revision int64) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteRollbackService(colony, colonyEnv, namespace, serviceName, revision)
+}
+
+// KubernetesCanaryPromote 金丝雀全量.
+func KubernetesCanaryPromote() error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteCanaryPromote(colony, colonyEnv, namespace, serviceName)
+}
+
+// KubernetesCanaryAbort 金丝雀终止.
+func KubernetesCanaryAbort() error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteCanaryAbort(colony, colonyEnv, namespace, serviceName)
+}
+
+// KubernetesStatus 查询服务实时状态, jsonOutput 为 true 时以 JSON 输出.
+func KubernetesStatus(jsonOutput bool) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteServiceStatus(colony, colonyEnv, namespace, serviceName, jsonOutput)
+}
+
+// KubernetesScale 修改服务副本数量.
+func KubernetesScale(replicas int32) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteScaleService(colony, colonyEnv, namespace, serviceName, replicas)
+}
+
+// KubernetesPause 暂停 (paused 为 false 时恢复) 服务发布.
+func KubernetesPause(paused bool) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecutePauseService(colony, colonyEnv, namespace, serviceName, paused)
+}
+
+// KubernetesLogs 输出服务 Pod 日志.
+func KubernetesLogs(options engine.LogOptions) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteServiceLogs(colony, colonyEnv, namespace, serviceName, options)
+}
+
+// KubernetesEvents 输出服务事件.
+func KubernetesEvents() error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	return engine.ExecuteServiceEvents(colony, colonyEnv, namespace, serviceName)
+}
+
+// KubernetesReleaseHistory 查询服务发布记录 (serviceName 为空时读取 P_SERVICE_NAME).
+func KubernetesReleaseHistory(serviceName string) error {
+	if serviceName == "" {
+		value, ok := environment.Get("P_SERVICE_NAME")
+		if !ok {
+			return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_SERVICE_NAME"))
+		}
+		serviceName = value
+	}
+	return engine.PrintReleaseHistory(serviceName)
+}
+
+// ImageResolve 输出镜像名称在每个集群的改写结果 (imageName 为空时读取 P_IMAGE_NAME).
+func ImageResolve(imageName string) error {
+	if imageName == "" {
+		value, ok := environment.Get("P_IMAGE_NAME")
+		if !ok {
+			return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_IMAGE_NAME"))
+		}
+		imageName = value
+	}
+	// 集群名称与集群环境 (可选)
+	colony, _ := environment.Get("P_COLONY")
+	colonyEnv, _ := environment.Get("colonyEnv")
+	return engine.PrintImageResolve(colony, colonyEnv, imageName)
+}
+
+// NacosSync 同步配置, plan 为 true 时仅输出同步计划与内容差异, prune 为 true 时删除本地不存在的配置.
+func NacosSync(plan, prune bool) error {
+	// 服务类型 (ALIYUN / TENCENT / NACOS)
 	serviceType, ok := environment.Get("P_SERVICE_TYPE")
 	if !ok {
 		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_SERVICE_TYPE"))
@@ -86,18 +212,33 @@ func NacosSync() error {
 	if !ok {
 		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_CONFIG_DIRECTORY"))
 	}
-
-	if serviceType == "ALIYUN" {
-		err := engine.AliyunNacosSync(instanceId, instanceNamespace, path.Join(workDirectory, nacosDirectory))
-		if err != nil {
-			return err
-		}
-	} else if serviceType == "TENCENT" {
-		err := engine.TencentNacosSync(instanceId, instanceNamespace, path.Join(workDirectory, nacosDirectory))
-		if err != nil {
-			return err
-		}
+	// 删除前备份目录 (推荐配置为工作目录以外的持久化绝对路径, 相对路径基于工作目录, 默认 .nacos-backup 需要声明为流水线制品)
+	backupDirectory, ok := environment.Get("P_NACOS_BACKUP_DIRECTORY")
+	if !ok {
+		backupDirectory = ".nacos-backup"
+	}
+	if !path.IsAbs(backupDirectory) {
+		backupDirectory = path.Join(workDirectory, backupDirectory)
 	}
 
-	return nil
+	return engine.ExecuteNacosSync(serviceType, instanceId, instanceNamespace, path.Join(workDirectory, nacosDirectory),
+		backupDirectory, plan, prune)
+}
+
+// K8sConfigSync 同步配置目录到 Kubernetes ConfigMap 与 Secret, restart 为 true 时配置变更后滚动重启服务, prune 为 true 时删除已移除的配置对象.
+func K8sConfigSync(restart, prune bool) error {
+	colony, colonyEnv, namespace, serviceName, err := kubernetesTarget()
+	if err != nil {
+		return err
+	}
+	// 配置目录
+	workDirectory, ok := environment.Get("CI_PROJECT_DIR")
+	if !ok {
+		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "CI_PROJECT_DIR"))
+	}
+	configDirectory, ok := environment.Get("P_K8S_CONFIG_DIRECTORY")
+	if !ok {
+		return errors.New(fmt.Sprintf("Environment variable ${%s} not exist", "P_K8S_CONFIG_DIRECTORY"))
+	}
+	return engine.ExecuteConfigSync(colony, colonyEnv, namespace, serviceName, path.Join(workDirectory, configDirectory), restart, prune)
 }
@@ -1,23 +1,17 @@
 package engine
 
 import (
-	"fmt"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/client"
 	mse "github.com/alibabacloud-go/mse-20190531/v3/client"
 	util "github.com/alibabacloud-go/tea-utils/service"
 	"github.com/alibabacloud-go/tea/tea"
-	"github.com/nuwa/bpp.v3/environment"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
-
-	"encoding/json"
 )
 
 type AliyunNacos struct {
@@ -40,13 +34,28 @@ func recursion(handle func() (bool, error)) error {
 	return nil
 }
 
+// 阿里云 MSE 默认接入地址
+const aliyunNacosEndpoint = "mse.cn-shanghai.aliyuncs.com"
+
+// 阿里云Nacos 配置列表每页数量 (接口上限 200)
+const aliyunNacosPageSize = 200
+
 // NewAliyunNacos 创建阿里云Nacos 客户端.
 func NewAliyunNacos(accessKeyId string, accessKeySecret string, instanceId string) (*AliyunNacos, error) {
-	client, err := mse.NewClient(&openapi.Config{
+	return NewAliyunNacosEndpoint(aliyunNacosEndpoint, accessKeyId, accessKeySecret, instanceId)
+}
+
+// NewAliyunNacosEndpoint 通过指定接入地址创建阿里云Nacos 客户端 (http:// 开头时使用 HTTP 协议).
+func NewAliyunNacosEndpoint(endpoint string, accessKeyId string, accessKeySecret string, instanceId string) (*AliyunNacos, error) {
+	var config = &openapi.Config{
 		AccessKeyId:     &accessKeyId,
 		AccessKeySecret: &accessKeySecret,
-		Endpoint:        tea.String("mse.cn-shanghai.aliyuncs.com"),
-	})
+		Endpoint:        tea.String(strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")),
+	}
+	if strings.HasPrefix(endpoint, "http://") {
+		config.Protocol = tea.String("HTTP")
+	}
+	client, err := mse.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
@@ -58,14 +67,15 @@ func NewAliyunNacos(accessKeyId string, accessKeySecret string, instanceId strin
 	}, nil
 }
 
-// GetNacosConfigList 获取Nacos配置列表.
+// GetNacosConfigList 获取Nacos配置列表 (逐页读取直到达到总数).
 func (aliyun *AliyunNacos) GetNacosConfigList(namespaceId string) ([]mse.ListNacosConfigsResponseBodyConfigurations, error) {
 	response := make([]mse.ListNacosConfigsResponseBodyConfigurations, 0)
+	var pageNum int32 = 1
 	err := recursion(func() (bool, error) {
 		listNacosConfigsRequest := &mse.ListNacosConfigsRequest{
 			InstanceId:  tea.String(aliyun.instanceId),
-			PageNum:     tea.Int32(1),
-			PageSize:    tea.Int32(200),
+			PageNum:     tea.Int32(pageNum),
+			PageSize:    tea.Int32(aliyunNacosPageSize),
 			NamespaceId: tea.String(namespaceId),
 		}
 		result, err := aliyun.client.ListNacosConfigsWithOptions(listNacosConfigsRequest, &util.RuntimeOptions{})
@@ -75,7 +85,8 @@ func (aliyun *AliyunNacos) GetNacosConfigList(namespaceId string) ([]mse.ListNac
 		for i := range result.Body.Configurations {
 			response = append(response, *result.Body.Configurations[i])
 		}
-		return (*result.Body.PageNumber)*(*result.Body.TotalCount) < *result.Body.TotalCount, nil
+		pageNum++
+		return len(result.Body.Configurations) > 0 && len(response) < int(tea.Int32Value(result.Body.TotalCount)), nil
 	})
 	if err != nil {
 		return nil, err
@@ -163,7 +174,7 @@ func (aliyun *AliyunNacos) PullNacos(namespaceId string, rootPath string) error
 	}
 	for i := range configList {
 		var item = configList[i]
-		log.Println(">>", namespaceId, "/", *item.DataId)
+		log.Println(">>", namespaceId, "/", *item.Group, "/", *item.DataId)
 		config, err := aliyun.GetNacosConfig(namespaceId, *item.Group, *item.DataId)
 		if err != nil {
 			return err
@@ -174,6 +185,14 @@ func (aliyun *AliyunNacos) PullNacos(namespaceId string, rootPath string) error
 		} else {
 			fileName = *config.DataId + "." + *config.Type
 		}
+		// 非默认分组写入分组目录 (与同步时的目录结构一致)
+		if *item.Group != nacosDefaultGroup {
+			fileName = path.Join(*item.Group, fileName)
+			err = os.MkdirAll(path.Join(rootPath, *item.Group), 0755)
+			if err != nil {
+				return err
+			}
+		}
 		fileWrite, err := os.Create(path.Join(rootPath, fileName))
 		if err != nil {
 			return err
@@ -186,137 +205,81 @@ func (aliyun *AliyunNacos) PullNacos(namespaceId string, rootPath string) error
 	return nil
 }
 
-// Sync 同步配置到线上.
-func (aliyun *AliyunNacos) Sync(namespaceId string, rootPath string) (*bool, error) {
-	log.Println(fmt.Sprintf("Sync Nacos Config By NamespaceId: %s", namespaceId))
-	// 读取本地磁盘
-	var files []string
-	var pathSeparator = string(os.PathSeparator)
-	rootPath = strings.ReplaceAll(rootPath, "\\", pathSeparator)
-	rootPath = strings.ReplaceAll(rootPath, "/", pathSeparator)
-	err := filepath.Walk(rootPath, func(path string, info fs.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		var relativePath = strings.TrimPrefix(path, rootPath)
-		files = append(files, strings.TrimPrefix(strings.TrimPrefix(relativePath, "/"), "\\"))
-		return nil
-	})
-	if err != nil {
-		return nil, err
+// Namespaces 获取命名空间列表.
+func (aliyun *AliyunNacos) Namespaces() ([]NacosNamespace, error) {
+	request := &mse.ListEngineNamespacesRequest{
+		InstanceId: tea.String(aliyun.instanceId),
 	}
-	// 读取线上配置
-	configList, err := aliyun.GetNacosConfigList(namespaceId)
+	result, err := aliyun.client.ListEngineNamespacesWithOptions(request, &util.RuntimeOptions{})
 	if err != nil {
 		return nil, err
 	}
-
-	// 是否存在新增
-	var addTask []string
-	var addTaskConfig []string
-	for i := range files {
-		_, result := lo.Find(configList, func(item mse.ListNacosConfigsResponseBodyConfigurations) bool {
-			return strings.TrimSuffix(files[i], path.Ext(files[i])) == *item.DataId
-		})
-		if !result {
-			addTask = append(addTask, files[i])
-			fileByte, err := os.ReadFile(path.Join(rootPath, files[i]))
-			if err != nil {
-				return nil, err
-			}
-			addTaskConfig = append(addTaskConfig, string(fileByte))
+	return lo.Map(result.Body.Data, func(item *mse.ListEngineNamespacesResponseBodyData, _ int) NacosNamespace {
+		return NacosNamespace{
+			Id:          tea.StringValue(item.Namespace),
+			Name:        tea.StringValue(item.NamespaceShowName),
+			ConfigCount: int(tea.Int32Value(item.ConfigCount)),
 		}
-	}
+	}), nil
+}
 
-	// 是否存在修改
-	var updateTask []string
-	var updateConfig []string
-	var updateConfigId []string
-	for i := range configList {
-		filePath, result := lo.Find(files, func(item string) bool {
-			return strings.TrimSuffix(item, path.Ext(item)) == *configList[i].DataId
-		})
-		if !result {
-			continue
-		}
-		var item = configList[i]
-		config, err := aliyun.GetNacosConfig(namespaceId, *item.Group, *item.DataId)
-		if err != nil {
-			return nil, err
-		}
-		fileByte, err := os.ReadFile(path.Join(rootPath, filePath))
-		if err != nil {
-			return nil, err
-		}
-		var fileContent = string(fileByte)
-		if *config.Content == fileContent && *config.Type == strings.TrimPrefix(path.Ext(filePath), ".") {
-			continue
-		}
-		updateTask = append(updateTask, filePath)
-		updateConfig = append(updateConfig, fileContent)
-		updateConfigId = append(updateConfigId, *config.DataId)
+// ListConfigs 获取配置列表.
+func (aliyun *AliyunNacos) ListConfigs(namespaceId string) ([]NacosConfig, error) {
+	configList, err := aliyun.GetNacosConfigList(namespaceId)
+	if err != nil {
+		return nil, err
 	}
+	return lo.Map(configList, func(item mse.ListNacosConfigsResponseBodyConfigurations, _ int) NacosConfig {
+		return NacosConfig{Group: tea.StringValue(item.Group), DataId: tea.StringValue(item.DataId)}
+	}), nil
+}
 
-	// 是否存在删除
-	var deleteTask []mse.ListNacosConfigsResponseBodyConfigurations
-	for i := range configList {
-		_, result := lo.Find(files, func(item string) bool {
-			return strings.TrimSuffix(item, path.Ext(item)) == *configList[i].DataId
-		})
-		if !result {
-			deleteTask = append(deleteTask, configList[i])
-		}
+// GetConfig 获取配置详情.
+func (aliyun *AliyunNacos) GetConfig(namespaceId string, group string, dataId string) (*NacosConfig, error) {
+	config, err := aliyun.GetNacosConfig(namespaceId, group, dataId)
+	if err != nil {
+		return nil, err
 	}
-
-	log.Println(fmt.Sprintf("Create: %d条", len(addTask)))
-	lo.ForEach(addTask, func(it string, i int) {
-		_, err := aliyun.CreateNacosConfig(namespaceId, "DEFAULT_GROUP",
-			strings.TrimSuffix(it, path.Ext(it)), &addTaskConfig[i], strings.TrimPrefix(path.Ext(it), "."))
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	log.Println(fmt.Sprintf("Update: %d条", len(updateTask)))
-	lo.ForEach(updateTask, func(it string, i int) {
-		_, err := aliyun.UpdateNacosConfig(namespaceId, "DEFAULT_GROUP", updateConfigId[i], &updateConfig[i], path.Ext(it))
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	log.Println(fmt.Sprintf("Delete: %d条", len(deleteTask)))
-	lo.ForEach(deleteTask, func(it mse.ListNacosConfigsResponseBodyConfigurations, _ int) {
-		_, err := aliyun.DeleteNacosConfig(namespaceId, *it.Group, *it.DataId)
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	return tea.Bool(true), nil
+	return &NacosConfig{
+		Group:   tea.StringValue(config.Group),
+		DataId:  tea.StringValue(config.DataId),
+		Content: tea.StringValue(config.Content),
+		Type:    tea.StringValue(config.Type),
+	}, nil
 }
 
-func AliyunNacosSync(instanceKey, instanceNamespace, nacosDirectory string) error {
-	var key = configNacosKey + instanceKey
-	instanceJson, ok := environment.Get(key)
-	if !ok {
-		return errors.New(fmt.Sprintf("Run Sync Nacos Not Find Param: %s", key))
-	}
-	instanceConfigMap := make(map[string]string)
-	err := json.Unmarshal([]byte(instanceJson), &instanceConfigMap)
+// aliyunResult 阿里云接口执行结果.
+func aliyunResult(success *bool, err error) error {
 	if err != nil {
 		return err
 	}
-	accessKeyId, accessKeyIdOk := instanceConfigMap["accessKeyId"]
-	accessKeySecret, accessKeySecretOk := instanceConfigMap["accessKeySecret"]
-	instanceId, instanceIdOk := instanceConfigMap["instanceId"]
-	if !accessKeyIdOk || !accessKeySecretOk || !instanceIdOk {
-		return errors.New("Aliyun Config Json Param Error")
-	}
-	aliyun, err := NewAliyunNacos(accessKeyId, accessKeySecret, instanceId)
-	if err != nil {
-		return err
+	if success != nil && !*success {
+		return errors.New("Response Fail")
 	}
-	_, err = aliyun.Sync(instanceNamespace, nacosDirectory)
+	return nil
+}
+
+// CreateConfig 创建配置.
+func (aliyun *AliyunNacos) CreateConfig(namespaceId string, config NacosConfig) error {
+	return aliyunResult(aliyun.CreateNacosConfig(namespaceId, config.Group, config.DataId, &config.Content, config.Type))
+}
+
+// UpdateConfig 修改配置.
+func (aliyun *AliyunNacos) UpdateConfig(namespaceId string, config NacosConfig) error {
+	return aliyunResult(aliyun.UpdateNacosConfig(namespaceId, config.Group, config.DataId, &config.Content, config.Type))
+}
+
+// DeleteConfig 删除配置.
+func (aliyun *AliyunNacos) DeleteConfig(namespaceId string, group string, dataId string) error {
+	return aliyunResult(aliyun.DeleteNacosConfig(namespaceId, group, dataId))
+}
+
+// newAliyunNacosProvider 通过实例配置 (accessKeyId / accessKeySecret / instanceId, 可选 endpoint) 创建阿里云Nacos 客户端.
+func newAliyunNacosProvider(config map[string]string) (NacosProvider, error) {
+	values, err := nacosConfigParams(nacosServiceAliyun, config, "accessKeyId", "accessKeySecret", "instanceId")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	var endpoint = lo.Ternary(config["endpoint"] == "", aliyunNacosEndpoint, config["endpoint"])
+	return NewAliyunNacosEndpoint(endpoint, values[0], values[1], values[2])
 }
@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// 蓝绿发布颜色标签 (Service 选择器通过该标签切换流量)
+const colorLabel = "bpp/color"
+
+// 蓝绿发布颜色
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+)
+
+// Service 切换前的颜色注解
+const previousColorAnnotation = "bpp/previous-color"
+
+// colorName 蓝绿发布服务名称
+func colorName(serviceName, serviceColor string) string {
+	return serviceName + "-" + serviceColor
+}
+
+// otherColor 另一种颜色
+func otherColor(serviceColor string) string {
+	return lo.Ternary(serviceColor == colorBlue, colorGreen, colorBlue)
+}
+
+// activeColor 查询 Service 当前指向的颜色 (为空表示尚未进行蓝绿发布)
+func (k Kubernetes) activeColor(namespace, serviceName string) (*v1.Service, string, error) {
+	service, err := k.CoreV1().Services(namespace).Get(context.Background(), serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	return service, service.Spec.Selector[colorLabel], nil
+}
+
+// colorWorkload 查询蓝绿发布的服务: idle 为 false 时为 Service 当前颜色的服务 (尚未蓝绿发布时为同名模板服务), 否则为空闲颜色的服务
+func (k Kubernetes) colorWorkload(namespace, serviceName string, idle bool) (*Workload, error) {
+	_, active, err := k.activeColor(namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if idle {
+		return k.GetWorkload(KindDeployment, namespace, colorName(serviceName, otherColor(active)))
+	}
+	return k.GetWorkload(KindDeployment, namespace, lo.Ternary(active == "", serviceName, colorName(serviceName, active)))
+}
+
+// colorDeployment 基于模板服务生成指定颜色的服务 (选择器与 Pod 模板附加颜色标签)
+func colorDeployment(source *v12.Deployment, serviceName, serviceColor string) *v12.Deployment {
+	var spec = source.Spec.DeepCopy()
+	spec.Selector.MatchLabels = lo.Assign(spec.Selector.MatchLabels, map[string]string{colorLabel: serviceColor})
+	spec.Template.Labels = lo.Assign(spec.Template.Labels, map[string]string{colorLabel: serviceColor})
+	return &v12.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      colorName(serviceName, serviceColor),
+			Namespace: source.Namespace,
+			Labels:    lo.Assign(source.Labels, map[string]string{colorLabel: serviceColor}),
+		},
+		Spec: *spec,
+	}
+}
+
+// createColorWorkload 以当前颜色 (或同名服务) 为模板创建空闲颜色的服务, 目标容器使用新镜像, 返回模板与新服务
+func (k Kubernetes) createColorWorkload(namespace, serviceName, active, idle, imageName string) (*Workload, *Workload, error) {
+	var sourceName = lo.Ternary(active == "", serviceName, colorName(serviceName, active))
+	source, err := k.getWorkload(KindDeployment, namespace, sourceName)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("%s -> %s/%s Blue Green Template Deployment %s Not: %s", k.colony, namespace, serviceName, sourceName, err))
+	}
+	containers, err := ResolveContainers(source, containerNames())
+	if err != nil {
+		return nil, nil, err
+	}
+	var deployment = colorDeployment(source.Object.(*v12.Deployment), serviceName, idle)
+	for _, item := range containers {
+		var container = lo.Ternary(item.Init, deployment.Spec.Template.Spec.InitContainers, deployment.Spec.Template.Spec.Containers)
+		container[item.Index].Image = imageName
+		container[item.Index].ImagePullPolicy = imagePullPolicyAlways
+	}
+	var options = metav1.CreateOptions{}
+	if k.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Create %s/%s From %s <- %s", namespace, deployment.Name, sourceName, imageName))
+	created, err := k.AppsV1().Deployments(namespace).Create(context.Background(), deployment, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, newWorkload(created), nil
+}
+
+// syncColorReplicas 空闲颜色服务的副本数量与当前颜色保持一致 (空闲颜色可能已被缩容), 返回修改后的服务
+func (k Kubernetes) syncColorReplicas(idle *Workload, namespace, activeName string) (*Workload, error) {
+	active, err := k.GetWorkload(KindDeployment, namespace, activeName)
+	if err != nil {
+		return nil, err
+	}
+	var replicas = active.Object.(*v12.Deployment).Spec.Replicas
+	var previous = idle.Object.(*v12.Deployment).Spec.Replicas
+	if replicas == nil || (previous != nil && *previous == *replicas) {
+		return idle, nil
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Sync %s Replicas From %s: %d -> %d", idle, activeName, lo.FromPtr(previous), *replicas))
+	requestByteData, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": *replicas},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.PatchWorkload(idle, types.MergePatchType, requestByteData)
+}
+
+// switchServiceColor 切换 Service 选择器到指定颜色, 并记录切换前的颜色
+func (k Kubernetes) switchServiceColor(service *v1.Service, serviceColor string) error {
+	color.Green(fmt.Sprintf("[Kubernetes] Switch Service %s/%s: %s -> %s", service.Namespace, service.Name,
+		lo.Ternary(service.Spec.Selector[colorLabel] == "", "-", service.Spec.Selector[colorLabel]), serviceColor))
+	requestByteData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{previousColorAnnotation: service.Spec.Selector[colorLabel]},
+		},
+		"spec": map[string]interface{}{
+			"selector": map[string]string{colorLabel: serviceColor},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = k.CoreV1().Services(service.Namespace).Patch(context.Background(), service.Name,
+		types.StrategicMergePatchType, requestByteData, k.patchOptions())
+	return err
+}
+
+// ReleaseBlueGreen 蓝绿发布: 发布空闲颜色的服务并等待就绪, 然后切换 Service 选择器, 旧颜色保留用于回滚
+func (k Kubernetes) ReleaseBlueGreen(namespace, serviceName, imageName string) error {
+	service, active, err := k.activeColor(namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	var idle = lo.Ternary(active == "", colorBlue, otherColor(active))
+	color.Blue(fmt.Sprintf("[Kubernetes] Blue Green %s/%s Active: %s Release: %s <- %s", namespace, serviceName,
+		lo.Ternary(active == "", "-", active), idle, imageName))
+	// 空闲颜色的服务存在时更新镜像, 否则以模板创建
+	var before, released *Workload
+	workload, err := k.getWorkload(KindDeployment, namespace, colorName(serviceName, idle))
+	if apierrors.IsNotFound(err) {
+		before, released, err = k.createColorWorkload(namespace, serviceName, active, idle, imageName)
+		workload = released
+	} else if err == nil {
+		before = workload
+		released, err = k.ReleaseService(workload, imageName)
+		if err == nil && active != "" {
+			workload, err = k.syncColorReplicas(workload, namespace, colorName(serviceName, active))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if k.dryRun {
+		printReleaseDiff(k.colony, before, released)
+		common.PrintTable([]string{"集群", "命名空间", "Service", "字段", "发布前", "发布后"}, [][]string{
+			{k.colony, namespace, serviceName, "selector." + colorLabel, active, idle},
+		})
+		return nil
+	}
+	err = k.WaitWorkloadRollout(workload, releaseTimeout())
+	if err == nil {
+		err = k.VerifyWorkloadHealth(workload, true)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s; service still points to %s", err, lo.Ternary(active == "", "-", active)))
+	}
+	return k.switchServiceColor(service, idle)
+}
+
+// RollbackBlueGreen 蓝绿回滚: 将 Service 切换回切换前的颜色 (未记录时为另一种颜色, 目标颜色的服务需全部就绪)
+func (k Kubernetes) RollbackBlueGreen(namespace, serviceName string) error {
+	service, active, err := k.activeColor(namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	if active == "" {
+		return errors.New(fmt.Sprintf("%s -> %s/%s Service Selector Has No %s", k.colony, namespace, serviceName, colorLabel))
+	}
+	var target = service.Annotations[previousColorAnnotation]
+	if target == "" || target == active {
+		target = otherColor(active)
+	}
+	workload, err := k.GetWorkload(KindDeployment, namespace, colorName(serviceName, target))
+	if err != nil {
+		return err
+	}
+	if !workloadReady(workload) {
+		return errors.New(fmt.Sprintf("%s -> %s Not Ready, Rollback Refused", k.colony, workload))
+	}
+	return k.switchServiceColor(service, target)
+}
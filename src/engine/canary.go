@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 发布策略 环境变量 (rolling: 滚动发布 / canary: 金丝雀发布 / bluegreen: 蓝绿发布)
+const releaseStrategyKey = "P_RELEASE_STRATEGY"
+
+// 发布策略
+const (
+	strategyRolling   = "rolling"
+	strategyCanary    = "canary"
+	strategyBlueGreen = "bluegreen"
+)
+
+// 金丝雀副本数量 环境变量 (单步发布)
+const canaryReplicasKey = "P_CANARY_REPLICAS"
+
+// 金丝雀分步副本数量 环境变量 (逗号分隔, 例如: 1,2,4, 优先于 P_CANARY_REPLICAS)
+const canaryStepsKey = "P_CANARY_STEPS"
+
+// 金丝雀每步观察时间 环境变量 (秒 或 Duration 格式)
+const canaryStepPauseKey = "P_CANARY_STEP_PAUSE"
+
+// 金丝雀全部步骤健康后自动全量 环境变量 (true / false)
+const canaryAutoPromoteKey = "P_CANARY_AUTO_PROMOTE"
+
+// 金丝雀服务名称后缀
+const canarySuffix = "-canary"
+
+// 金丝雀标签 (区分金丝雀与主服务的 Pod 选择器)
+const (
+	trackLabel       = "bpp/track"
+	trackLabelCanary = "canary"
+)
+
+// releaseStrategy 读取发布策略
+func releaseStrategy() (string, error) {
+	value, ok := environment.Get(releaseStrategyKey)
+	if !ok {
+		return strategyRolling, nil
+	}
+	value = strings.ToLower(value)
+	if !lo.Contains([]string{strategyRolling, strategyCanary, strategyBlueGreen}, value) {
+		return "", errors.New(fmt.Sprintf("Environment variable ${%s}=%s not support (%s / %s / %s)",
+			releaseStrategyKey, value, strategyRolling, strategyCanary, strategyBlueGreen))
+	}
+	return value, nil
+}
+
+// canarySteps 读取金丝雀分步副本数量
+func canarySteps() ([]int32, error) {
+	value, ok := environment.Get(canaryStepsKey)
+	if !ok {
+		value, ok = environment.Get(canaryReplicasKey)
+	}
+	if !ok {
+		return []int32{1}, nil
+	}
+	var steps []int32
+	for _, item := range strings.Split(value, ",") {
+		replicas, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || replicas <= 0 {
+			return nil, errors.New(fmt.Sprintf("Canary Steps %s Format Error", value))
+		}
+		steps = append(steps, int32(replicas))
+	}
+	return steps, nil
+}
+
+// canaryStepPause 读取金丝雀每步观察时间
+func canaryStepPause() time.Duration {
+	value, ok := environment.Get(canaryStepPauseKey)
+	if !ok {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return duration
+	}
+	color.Yellow(fmt.Sprintf("[Kubernetes] %s=%s 格式错误, 不等待", canaryStepPauseKey, value))
+	return 0
+}
+
+// canaryName 金丝雀服务名称
+func canaryName(serviceName string) string {
+	return serviceName + canarySuffix
+}
+
+// canaryDeployment 基于主服务生成金丝雀服务 (选择器与 Pod 模板附加金丝雀标签, 仍可被主服务的 Service 选中)
+func canaryDeployment(main *v12.Deployment, containers []ContainerTarget, imageName string, replicas int32) *v12.Deployment {
+	var spec = main.Spec.DeepCopy()
+	spec.Replicas = &replicas
+	spec.Selector.MatchLabels = lo.Assign(spec.Selector.MatchLabels, map[string]string{trackLabel: trackLabelCanary})
+	spec.Template.Labels = lo.Assign(spec.Template.Labels, map[string]string{trackLabel: trackLabelCanary})
+	for _, item := range containers {
+		var container = lo.Ternary(item.Init, spec.Template.Spec.InitContainers, spec.Template.Spec.Containers)
+		container[item.Index].Image = imageName
+		container[item.Index].ImagePullPolicy = imagePullPolicyAlways
+	}
+	return &v12.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      canaryName(main.Name),
+			Namespace: main.Namespace,
+			Labels:    lo.Assign(main.Labels, map[string]string{trackLabel: trackLabelCanary}),
+		},
+		Spec: *spec,
+	}
+}
+
+// applyCanary 创建或更新金丝雀服务
+func (k Kubernetes) applyCanary(canary *v12.Deployment) (*v12.Deployment, error) {
+	var ctx = context.Background()
+	var client = k.AppsV1().Deployments(canary.Namespace)
+	var createOptions = metav1.CreateOptions{}
+	var updateOptions = metav1.UpdateOptions{}
+	if k.dryRun {
+		createOptions.DryRun = []string{metav1.DryRunAll}
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	current, err := client.Get(ctx, canary.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		color.Green(fmt.Sprintf("[Kubernetes] Create Canary %s/%s Replicas: %d", canary.Namespace, canary.Name, *canary.Spec.Replicas))
+		return client.Create(ctx, canary, createOptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Update Canary %s/%s Replicas: %d", canary.Namespace, canary.Name, *canary.Spec.Replicas))
+	current.Labels = canary.Labels
+	current.Spec.Replicas = canary.Spec.Replicas
+	current.Spec.Template = canary.Spec.Template
+	return client.Update(ctx, current, updateOptions)
+}
+
+// deleteCanary 删除金丝雀服务
+func (k Kubernetes) deleteCanary(namespace, serviceName string) error {
+	color.Yellow(fmt.Sprintf("[Kubernetes] Delete Canary %s/%s", namespace, canaryName(serviceName)))
+	var options = metav1.DeleteOptions{}
+	if k.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	err := k.AppsV1().Deployments(namespace).Delete(context.Background(), canaryName(serviceName), options)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ReleaseCanary 金丝雀发布: 按步骤创建或扩容金丝雀服务并等待健康, 失败时删除金丝雀服务
+func (k Kubernetes) ReleaseCanary(workload *Workload, imageName string) error {
+	main, ok := workload.Object.(*v12.Deployment)
+	if !ok {
+		return errors.New(fmt.Sprintf("%s -> %s Canary Release Only Support Deployment", k.colony, workload))
+	}
+	containers, err := ResolveContainers(workload, containerNames())
+	if err != nil {
+		return err
+	}
+	steps, err := canarySteps()
+	if err != nil {
+		return err
+	}
+	for index, replicas := range steps {
+		color.Blue(fmt.Sprintf("[Kubernetes] Canary Step %d/%d: %s Replicas %d <- %s", index+1, len(steps), workload, replicas, imageName))
+		canary, err := k.applyCanary(canaryDeployment(main, containers, imageName, replicas))
+		if err != nil {
+			return err
+		}
+		if k.dryRun {
+			printReleaseDiff(k.colony, workload, newWorkload(canary))
+			return nil
+		}
+		err = k.WaitWorkloadRollout(newWorkload(canary), releaseTimeout())
+		if err == nil {
+			err = k.VerifyWorkloadHealth(newWorkload(canary), true)
+		}
+		if err != nil {
+			if deleteErr := k.deleteCanary(main.Namespace, main.Name); deleteErr != nil {
+				return errors.New(fmt.Sprintf("%s; delete canary fail: %s", err, deleteErr))
+			}
+			return errors.New(fmt.Sprintf("%s; canary aborted", err))
+		}
+		if pause := canaryStepPause(); pause > 0 {
+			color.Blue(fmt.Sprintf("[Kubernetes] Canary Step %d/%d Healthy, Observe %s ...", index+1, len(steps), pause))
+			time.Sleep(pause)
+		}
+	}
+	if environmentBool(canaryAutoPromoteKey, false) {
+		return k.PromoteCanary(workload.Namespace, workload.Name)
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] %s -> %s Canary Healthy, Run `release promote` Or `release abort`", k.colony, workload))
+	return nil
+}
+
+// PromoteCanary 金丝雀全量: 将金丝雀服务的镜像发布到主服务, 成功后删除金丝雀服务
+func (k Kubernetes) PromoteCanary(namespace, serviceName string) error {
+	canary, err := k.GetWorkload(KindDeployment, namespace, canaryName(serviceName))
+	if err != nil {
+		return err
+	}
+	workload, err := k.GetWorkload(KindDeployment, namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	var previousTemplate = workload.Template.DeepCopy()
+	var operations []map[string]interface{}
+	var names = append(lo.Map(canary.Template.Spec.Containers, func(item v1.Container, _ int) string { return item.Name }),
+		lo.Map(canary.Template.Spec.InitContainers, func(item v1.Container, _ int) string { return item.Name })...)
+	targets, err := ResolveContainers(workload, names)
+	if err != nil {
+		return err
+	}
+	canaryTargets, err := ResolveContainers(canary, names)
+	if err != nil {
+		return err
+	}
+	for i, target := range targets {
+		var image = canaryTargets[i].container(canary.Template).Image
+		if target.container(workload.Template).Image == image {
+			continue
+		}
+		operations = append(operations, containerOperations([]ContainerTarget{target}, "image", image)...)
+		operations = append(operations, containerOperations([]ContainerTarget{target}, "imagePullPolicy", imagePullPolicyAlways)...)
+	}
+	if len(operations) == 0 {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Image Unchanged, Rolling Restart", workload))
+		operations = append(operations, restartOperation(workload))
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Promote Canary %s <- %s", workload, templateImages(canary.Template)))
+	_, err = k.patchWorkloadTemplate(workload, operations...)
+	if err == nil {
+		err = k.WaitWorkloadRollout(workload, releaseTimeout())
+	}
+	if err == nil {
+		err = k.VerifyWorkloadHealth(workload, false)
+	}
+	if err != nil {
+		if autoRollback() {
+			return k.rollbackRelease(workload, previousTemplate, err)
+		}
+		return err
+	}
+	return k.deleteCanary(namespace, serviceName)
+}
+
+// AbortCanary 金丝雀终止: 删除金丝雀服务, 主服务保持不变
+func (k Kubernetes) AbortCanary(namespace, serviceName string) error {
+	return k.deleteCanary(namespace, serviceName)
+}
+
+// ExecuteCanaryPromote 执行金丝雀全量.
+func ExecuteCanaryPromote(colony, env, namespace, serviceName string) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 金丝雀全量", colony, env, namespace, serviceName))
+	return eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		return kubernetesClient.PromoteCanary(namespace, serviceName)
+	})
+}
+
+// ExecuteCanaryAbort 执行金丝雀终止.
+func ExecuteCanaryAbort(colony, env, namespace, serviceName string) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 金丝雀终止", colony, env, namespace, serviceName))
+	return eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		return kubernetesClient.AbortCanary(namespace, serviceName)
+	})
+}
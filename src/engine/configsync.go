@@ -0,0 +1,350 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/samber/lo"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// 配置变更后滚动重启服务 环境变量 (true / false)
+const configRestartKey = "P_K8S_CONFIG_RESTART"
+
+// 删除目录中已移除的配置对象 环境变量 (true / false, 默认 false)
+const configPruneKey = "P_K8S_CONFIG_PRUNE"
+
+// 配置目录结构: <目录>/configmap/<名称>/<文件> 与 <目录>/secret/<名称>/<文件>, 每个文件为一个配置项
+const (
+	configMapDirectory = "configmap"
+	secretDirectory    = "secret"
+)
+
+// 配置对象类型
+const (
+	kindConfigMap = "ConfigMap"
+	kindSecret    = "Secret"
+)
+
+// 配置对象管理标签 (仅删除由 bpp 管理且属于该服务的配置对象)
+const (
+	managedByLabel      = "bpp/managed-by"
+	managedByLabelValue = "bpp"
+	serviceLabel        = "bpp/service"
+)
+
+// 配置对象内容摘要注解 (仅 ConfigMap, Secret 直接对比线上内容, 避免通过摘要反推密钥)
+const contentHashAnnotation = "bpp/content-hash"
+
+// Pod 模板配置摘要注解 (配置变更时修改该注解触发滚动重启, Secret 使用资源版本参与计算)
+const configHashAnnotation = "bpp/config-hash"
+
+// 配置同步操作
+const (
+	configCreate    = "Create"
+	configUpdate    = "Update"
+	configDelete    = "Delete"
+	configUnchanged = "Unchanged"
+)
+
+// configObject 目录中的配置对象
+type configObject struct {
+	Kind string            // 类型 (ConfigMap / Secret)
+	Name string            // 名称
+	Data map[string][]byte // 配置项
+}
+
+// hash 配置项内容摘要 (按配置项名称排序)
+func (c configObject) hash() string {
+	var keys = lo.Keys(c.Data)
+	sort.Strings(keys)
+	var hash = sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(c.Data[key])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// readConfigObjects 读取配置目录中的 ConfigMap 与 Secret
+func readConfigObjects(rootPath string) ([]configObject, error) {
+	if _, err := os.Stat(rootPath); err != nil {
+		return nil, errors.New(fmt.Sprintf("Kubernetes Config Directory %s Not: %s", rootPath, err))
+	}
+	var objects []configObject
+	for _, item := range []struct{ directory, kind string }{{configMapDirectory, kindConfigMap}, {secretDirectory, kindSecret}} {
+		entries, err := os.ReadDir(filepath.Join(rootPath, item.directory))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			var object = configObject{Kind: item.kind, Name: entry.Name(), Data: map[string][]byte{}}
+			files, err := os.ReadDir(filepath.Join(rootPath, item.directory, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if file.IsDir() {
+					continue
+				}
+				content, err := os.ReadFile(filepath.Join(rootPath, item.directory, entry.Name(), file.Name()))
+				if err != nil {
+					return nil, err
+				}
+				object.Data[file.Name()] = content
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// configObjectMeta 配置对象元数据 (管理标签, ConfigMap 写入内容摘要, Secret 移除内容摘要)
+func configObjectMeta(namespace, serviceName string, object configObject, current metav1.ObjectMeta) metav1.ObjectMeta {
+	current.Name, current.Namespace = object.Name, namespace
+	current.Labels = lo.Assign(current.Labels, map[string]string{managedByLabel: managedByLabelValue, serviceLabel: serviceName})
+	if object.Kind == kindSecret {
+		current.Annotations = lo.OmitByKeys(current.Annotations, []string{contentHashAnnotation})
+	} else {
+		current.Annotations = lo.Assign(current.Annotations, map[string]string{contentHashAnnotation: object.hash()})
+	}
+	return current
+}
+
+// binaryDataEqual 二进制线上内容 (Secret data / ConfigMap binaryData) 与目录内容是否一致
+func binaryDataEqual(current map[string][]byte, data map[string][]byte) bool {
+	if len(current) != len(data) {
+		return false
+	}
+	for key, value := range data {
+		currentValue, ok := current[key]
+		if !ok || !bytes.Equal(currentValue, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// configMapDataEqual 比较 ConfigMap 线上内容与目录内容是否一致
+func configMapDataEqual(current map[string]string, data map[string]string) bool {
+	if len(current) != len(data) {
+		return false
+	}
+	for key, value := range data {
+		currentValue, ok := current[key]
+		if !ok || currentValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// syncConfigMap 创建或更新 ConfigMap (非 UTF-8 内容写入 binaryData), 返回执行的操作
+func (k Kubernetes) syncConfigMap(namespace, serviceName string, object configObject) (string, error) {
+	var ctx = context.Background()
+	var client = k.CoreV1().ConfigMaps(namespace)
+	current, err := client.Get(ctx, object.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	var data, binaryData = map[string]string{}, map[string][]byte{}
+	for key, value := range object.Data {
+		if utf8.Valid(value) {
+			data[key] = string(value)
+		} else {
+			binaryData[key] = value
+		}
+	}
+	var exists = err == nil
+	if !exists {
+		current = &v1.ConfigMap{}
+	} else if current.Annotations[contentHashAnnotation] == object.hash() &&
+		configMapDataEqual(current.Data, data) && binaryDataEqual(current.BinaryData, binaryData) {
+		// 注解仅用于快速判断, 仍比较线上内容以发现手动修改
+		return configUnchanged, nil
+	}
+	current.ObjectMeta = configObjectMeta(namespace, serviceName, object, current.ObjectMeta)
+	current.Data, current.BinaryData = data, binaryData
+	if exists {
+		_, err = client.Update(ctx, current, metav1.UpdateOptions{})
+		return configUpdate, err
+	}
+	_, err = client.Create(ctx, current, metav1.CreateOptions{})
+	return configCreate, err
+}
+
+// syncSecret 创建或更新 Secret (Opaque, 直接对比线上内容), 返回执行的操作
+func (k Kubernetes) syncSecret(namespace, serviceName string, object configObject) (string, error) {
+	var ctx = context.Background()
+	var client = k.CoreV1().Secrets(namespace)
+	current, err := client.Get(ctx, object.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	var exists = err == nil
+	if !exists {
+		current = &v1.Secret{Type: v1.SecretTypeOpaque}
+	} else if _, legacy := current.Annotations[contentHashAnnotation]; !legacy && binaryDataEqual(current.Data, object.Data) {
+		return configUnchanged, nil
+	}
+	current.ObjectMeta = configObjectMeta(namespace, serviceName, object, current.ObjectMeta)
+	current.Data = object.Data
+	if exists {
+		_, err = client.Update(ctx, current, metav1.UpdateOptions{})
+		return configUpdate, err
+	}
+	_, err = client.Create(ctx, current, metav1.CreateOptions{})
+	return configCreate, err
+}
+
+// pruneConfigObjects 删除目录中已移除且由 bpp 管理的该服务配置对象, 返回删除的对象
+func (k Kubernetes) pruneConfigObjects(namespace, serviceName string, objects []configObject) ([]configObject, error) {
+	var ctx = context.Background()
+	var options = metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s=%s", managedByLabel, managedByLabelValue, serviceLabel, serviceName)}
+	var exists = func(kind, name string) bool {
+		return lo.ContainsBy(objects, func(item configObject) bool { return item.Kind == kind && item.Name == name })
+	}
+	var deleted []configObject
+	configMaps, err := k.CoreV1().ConfigMaps(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range configMaps.Items {
+		if exists(kindConfigMap, item.Name) {
+			continue
+		}
+		if err := k.CoreV1().ConfigMaps(namespace).Delete(ctx, item.Name, metav1.DeleteOptions{}); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, configObject{Kind: kindConfigMap, Name: item.Name, Data: map[string][]byte{}})
+	}
+	secrets, err := k.CoreV1().Secrets(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range secrets.Items {
+		if exists(kindSecret, item.Name) {
+			continue
+		}
+		if err := k.CoreV1().Secrets(namespace).Delete(ctx, item.Name, metav1.DeleteOptions{}); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, configObject{Kind: kindSecret, Name: item.Name, Data: map[string][]byte{}})
+	}
+	return deleted, nil
+}
+
+// restartOnConfigChange 配置摘要与 Pod 模板注解不一致时修改注解, 触发服务滚动重启
+func (k Kubernetes) restartOnConfigChange(kind, namespace, serviceName string, objects []configObject) error {
+	workload, err := k.GetWorkload(kind, namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	// ConfigMap 使用内容摘要, Secret 使用资源版本 (仅内容变化时更新)
+	var data = map[string][]byte{}
+	for _, item := range objects {
+		if item.Kind != kindSecret {
+			data[item.Kind+"/"+item.Name] = []byte(item.hash())
+			continue
+		}
+		secret, err := k.CoreV1().Secrets(namespace).Get(context.Background(), item.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		data[item.Kind+"/"+item.Name] = []byte(string(secret.UID) + "/" + secret.ResourceVersion)
+	}
+	var hash = configObject{Data: data}.hash()
+	if workload.Template.Annotations[configHashAnnotation] == hash {
+		color.Blue(fmt.Sprintf("[Kubernetes] %s Config Unchanged, Skip Restart", workload))
+		return nil
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] %s Config Changed, Rolling Restart ...", workload))
+	_, err = k.patchWorkloadTemplate(workload, templateAnnotationOperation(workload, configHashAnnotation, hash))
+	if err != nil {
+		return err
+	}
+	return k.WaitWorkloadRollout(workload, releaseTimeout())
+}
+
+// ExecuteConfigSync 同步配置目录到全部 集群 x 命名空间 的 ConfigMap 与 Secret, restart 为 true 时配置变更后滚动重启服务,
+// prune 为 true 时删除目录中已移除的配置对象
+func ExecuteConfigSync(colony, env, namespace, serviceName, rootPath string, restart, prune bool) error {
+	restart = restart || environmentBool(configRestartKey, false)
+	prune = prune || environmentBool(configPruneKey, false)
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 配置目录: %s 滚动重启: %t 删除已移除对象: %t",
+		colony, env, namespace, serviceName, rootPath, restart, prune))
+	objects, err := readConfigObjects(rootPath)
+	if err != nil {
+		return err
+	}
+	if prune && len(objects) == 0 {
+		// 目录中没有任何配置对象时 (目录错误或结构错误) 拒绝删除, 避免删除全部配置对象
+		return errors.New(fmt.Sprintf("Kubernetes Config Directory %s Has No %s/<名称> Or %s/<名称>, Refuse To Prune",
+			rootPath, configMapDirectory, secretDirectory))
+	}
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	var lock sync.Mutex
+	var rows [][]string
+	err = eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		var appendRow = func(object configObject, operation string) {
+			lock.Lock()
+			defer lock.Unlock()
+			rows = append(rows, []string{colony, namespace, object.Kind, object.Name, operation, strconv.Itoa(len(object.Data))})
+		}
+		for _, object := range objects {
+			var operation string
+			if object.Kind == kindSecret {
+				operation, err = kubernetesClient.syncSecret(namespace, serviceName, object)
+			} else {
+				operation, err = kubernetesClient.syncConfigMap(namespace, serviceName, object)
+			}
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s %s Sync Fail: %s", object.Kind, object.Name, err))
+			}
+			appendRow(object, operation)
+		}
+		if prune {
+			deleted, err := kubernetesClient.pruneConfigObjects(namespace, serviceName, objects)
+			if err != nil {
+				return err
+			}
+			for _, object := range deleted {
+				appendRow(object, configDelete)
+			}
+		}
+		if !restart {
+			return nil
+		}
+		return kubernetesClient.restartOnConfigChange(kind, namespace, serviceName, objects)
+	})
+	common.PrintTable([]string{"集群", "命名空间", "类型", "名称", "操作", "配置项"}, rows)
+	return err
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"k8s.io/api/core/v1"
+	"strings"
+)
+
+// 发布容器名称 环境变量 (多个使用逗号分隔, 为空时发布第一个容器)
+const containerNameKey = "P_CONTAINER_NAME"
+
+// ContainerTarget 发布目标容器
+type ContainerTarget struct {
+	Name  string // 容器名称
+	Index int    // 容器下标
+	Init  bool   // 是否为初始化容器
+}
+
+// path 容器相对 Pod 模板的 JSON Patch 路径
+func (c ContainerTarget) path() string {
+	return fmt.Sprintf("/spec/%s/%d", lo.Ternary(c.Init, "initContainers", "containers"), c.Index)
+}
+
+// container 读取 Pod 模板中的目标容器
+func (c ContainerTarget) container(template *v1.PodTemplateSpec) v1.Container {
+	if c.Init {
+		return template.Spec.InitContainers[c.Index]
+	}
+	return template.Spec.Containers[c.Index]
+}
+
+// containerNames 读取发布容器名称
+func containerNames() []string {
+	value, ok := environment.Get(containerNameKey)
+	if !ok {
+		return nil
+	}
+	return lo.Compact(lo.Map(strings.Split(value, ","), func(item string, _ int) string {
+		return strings.TrimSpace(item)
+	}))
+}
+
+// ResolveContainers 按名称解析目标容器位置 (优先匹配业务容器, 其次初始化容器), names 为空时使用第一个容器
+func ResolveContainers(workload *Workload, names []string) ([]ContainerTarget, error) {
+	var spec = workload.Template.Spec
+	if len(names) == 0 {
+		if len(spec.Containers) == 0 {
+			return nil, errors.New(fmt.Sprintf("%s Containers Empty", workload))
+		}
+		return []ContainerTarget{{Name: spec.Containers[0].Name, Index: 0}}, nil
+	}
+	var targets []ContainerTarget
+	for _, name := range names {
+		_, index, ok := lo.FindIndexOf(spec.Containers, func(item v1.Container) bool { return item.Name == name })
+		if ok {
+			targets = append(targets, ContainerTarget{Name: name, Index: index})
+			continue
+		}
+		_, index, ok = lo.FindIndexOf(spec.InitContainers, func(item v1.Container) bool { return item.Name == name })
+		if ok {
+			targets = append(targets, ContainerTarget{Name: name, Index: index, Init: true})
+			continue
+		}
+		var containerName = func(item v1.Container, _ int) string { return item.Name }
+		return nil, errors.New(fmt.Sprintf("%s Container %s Not (Containers: %s; InitContainers: %s)", workload, name,
+			strings.Join(lo.Map(spec.Containers, containerName), ", "), strings.Join(lo.Map(spec.InitContainers, containerName), ", ")))
+	}
+	return targets, nil
+}
@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 发布时固定镜像摘要 环境变量 (true / false, 通过 OCI Distribution API 将版本号解析为 sha256 摘要)
+const imageDigestKey = "P_IMAGE_DIGEST"
+
+// 镜像仓库认证类型 环境变量 (认证信息读取 GL_DOCKER_AUTH_<类型>, 格式: 用户名,密码[,仓库地址])
+const dockerAuthTypeKey = "P_DOCKER_AUTH_TYPE"
+
+// 镜像仓库认证信息 环境变量前缀
+const dockerAuthKeyPrefix = "GL_DOCKER_AUTH_"
+
+// 默认镜像仓库地址 (认证信息未指定仓库地址时使用)
+const defaultDockerRegistry = "registry.cn-shanghai.aliyuncs.com"
+
+// Docker Hub 仓库地址
+const dockerHubRegistry = "registry-1.docker.io"
+
+// 镜像仓库请求超时时间
+const registryRequestTimeout = 30 * time.Second
+
+// 镜像清单类型 (优先多架构清单)
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RegistryAuth 镜像仓库认证信息
+type RegistryAuth struct {
+	Registry string // 仓库地址
+	Username string // 用户名
+	Password string // 密码
+}
+
+// dockerAuth 读取镜像仓库认证信息 (未配置时返回 nil)
+func dockerAuth() (*RegistryAuth, error) {
+	authType, ok := environment.Get(dockerAuthTypeKey)
+	if !ok {
+		return nil, nil
+	}
+	value, ok := environment.Get(dockerAuthKeyPrefix + authType)
+	if !ok {
+		return nil, nil
+	}
+	var values = strings.Split(value, ",")
+	switch len(values) {
+	case 2:
+		return &RegistryAuth{Registry: defaultDockerRegistry, Username: values[0], Password: values[1]}, nil
+	case 3:
+		return &RegistryAuth{Registry: values[2], Username: values[0], Password: values[1]}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Environment variable ${%s%s} Docker Auth Format Error (username,password[,registry])",
+		dockerAuthKeyPrefix, authType))
+}
+
+// registryUrl 镜像仓库 API 地址 (本地回环地址使用 http)
+func registryUrl(registry string) string {
+	var host = registry
+	if value, _, err := net.SplitHostPort(registry); err == nil {
+		host = value
+	}
+	var ip = net.ParseIP(host)
+	return lo.Ternary(host == "localhost" || (ip != nil && ip.IsLoopback()), "http://", "https://") + registry
+}
+
+// registryChallenge 解析 WWW-Authenticate 认证质询 (Bearer realm="...",service="...",scope="...")
+func registryChallenge(header string) (string, map[string]string) {
+	var scheme, rest, _ = strings.Cut(strings.TrimSpace(header), " ")
+	var params = map[string]string{}
+	for _, item := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if ok {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return strings.ToLower(scheme), params
+}
+
+// registryToken 向认证服务申请 Bearer 令牌
+func registryToken(client *http.Client, params map[string]string, auth *RegistryAuth) (string, error) {
+	tokenUrl, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	var query = tokenUrl.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenUrl.RawQuery = query.Encode()
+	request, err := http.NewRequest(http.MethodGet, tokenUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth != nil {
+		request.SetBasicAuth(auth.Username, auth.Password)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("registry token status %d", response.StatusCode))
+	}
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+	return lo.Ternary(result.Token == "", result.AccessToken, result.Token), nil
+}
+
+// ResolveImageDigest 通过 OCI Distribution API 查询镜像版本号对应的 sha256 摘要 (支持匿名、Basic 与 Bearer 认证)
+func ResolveImageDigest(imageName string, auth *RegistryAuth) (string, error) {
+	var reference = ParseImageReference(imageName)
+	if reference.Digest != "" {
+		return reference.Digest, nil
+	}
+	var registry = reference.Registry
+	var repository = reference.Repository
+	if registry == "" {
+		registry = dockerHubRegistry
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	if auth != nil && auth.Registry != registry {
+		auth = nil
+	}
+	var manifestUrl = fmt.Sprintf("%s/v2/%s/manifests/%s", registryUrl(registry), repository, lo.Ternary(reference.Tag == "", "latest", reference.Tag))
+	var client = &http.Client{Timeout: registryRequestTimeout}
+	var authorization string
+	for attempt := 0; attempt < 2; attempt++ {
+		request, err := http.NewRequest(http.MethodGet, manifestUrl, nil)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if response.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			// 按认证质询获取凭证后重试
+			scheme, params := registryChallenge(response.Header.Get("WWW-Authenticate"))
+			switch {
+			case scheme == "bearer":
+				token, err := registryToken(client, params, auth)
+				if err != nil {
+					return "", errors.New(fmt.Sprintf("Image %s Registry Auth Fail: %s", imageName, err))
+				}
+				authorization = "Bearer " + token
+			case scheme == "basic" && auth != nil:
+				request.SetBasicAuth(auth.Username, auth.Password)
+				authorization = request.Header.Get("Authorization")
+			default:
+				return "", errors.New(fmt.Sprintf("Image %s Registry Unauthorized", imageName))
+			}
+			continue
+		}
+		if response.StatusCode != http.StatusOK {
+			return "", errors.New(fmt.Sprintf("Image %s Manifest Status %d: %s", imageName, response.StatusCode, strings.TrimSpace(string(body))))
+		}
+		if digest := response.Header.Get("Docker-Content-Digest"); digest != "" {
+			return digest, nil
+		}
+		var hash = sha256.Sum256(body)
+		return "sha256:" + hex.EncodeToString(hash[:]), nil
+	}
+	return "", errors.New(fmt.Sprintf("Image %s Registry Unauthorized", imageName))
+}
+
+// ImageDigests 按集群改写后的镜像名称缓存摘要 (多个目标发布同一镜像时只查询一次)
+type ImageDigests struct {
+	lock    sync.Mutex
+	auth    *RegistryAuth
+	digests map[string]string
+}
+
+// NewImageDigests 创建镜像摘要缓存 (auth 为仓库认证信息, 可为 nil)
+func NewImageDigests(auth *RegistryAuth) *ImageDigests {
+	return &ImageDigests{auth: auth, digests: map[string]string{}}
+}
+
+// releaseImageDigests 读取是否固定镜像摘要, 开启时使用已配置的仓库认证信息创建摘要缓存 (未开启时返回 nil)
+func releaseImageDigests() (*ImageDigests, error) {
+	if !environmentBool(imageDigestKey, false) {
+		return nil, nil
+	}
+	auth, err := dockerAuth()
+	if err != nil {
+		return nil, err
+	}
+	return NewImageDigests(auth), nil
+}
+
+// Resolve 按集群改写镜像名称并固定为改写后镜像的摘要 (缓存为 nil 或改写结果已包含摘要时不查询)
+func (d *ImageDigests) Resolve(colony, env, imageName string) (string, error) {
+	newImageName, _, err := ResolveImageName(colony, env, imageName)
+	if err != nil {
+		return "", err
+	}
+	if d == nil || ParseImageReference(newImageName).Digest != "" {
+		return newImageName, nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	digest, ok := d.digests[newImageName]
+	if !ok {
+		digest, err = ResolveImageDigest(newImageName, d.auth)
+		if err != nil {
+			return "", err
+		}
+		color.Blue(fmt.Sprintf("[Kubernetes] Image %s Digest: %s", newImageName, digest))
+		d.digests[newImageName] = digest
+	}
+	return pinImageDigest(newImageName, digest), nil
+}
+
+// pinImageDigest 将镜像名称固定为摘要 (repo@sha256:..., 摘要为空时不修改)
+func pinImageDigest(imageName, digest string) string {
+	if digest == "" {
+		return imageName
+	}
+	var reference = ParseImageReference(imageName)
+	reference.Digest = digest
+	return reference.String()
+}
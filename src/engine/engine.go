@@ -1,3 +1,54 @@
 package engine
 
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"strconv"
+	"time"
+)
+
 const configNacosKey = "GL_NACOS_CONFIG_"
+
+// environmentBool 读取布尔类型的环境变量
+func environmentBool(key string, defaultValue bool) bool {
+	value, ok := environment.Get(key)
+	if !ok {
+		return defaultValue
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return result
+}
+
+// environmentInt 读取整数类型的环境变量
+func environmentInt(key string, defaultValue int) int {
+	value, ok := environment.Get(key)
+	if !ok {
+		return defaultValue
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result < 0 {
+		color.Yellow(fmt.Sprintf("%s=%s 格式错误, 使用默认值 %d", key, value, defaultValue))
+		return defaultValue
+	}
+	return result
+}
+
+// environmentDuration 读取时间类型的环境变量 (秒 或 Duration 格式, 例如: 300 / 5m)
+func environmentDuration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := environment.Get(key)
+	if !ok {
+		return defaultValue
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return duration
+	}
+	color.Yellow(fmt.Sprintf("[Kubernetes] %s=%s 格式错误, 使用默认值 %s", key, value, defaultValue))
+	return defaultValue
+}
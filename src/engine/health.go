@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"io"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 健康检查路径 环境变量 (为空时不进行发布后健康检查, 例如: /actuator/health)
+const healthPathKey = "P_HEALTH_PATH"
+
+// 健康检查服务地址 环境变量 (集群内执行时使用 Service 地址, 例如: http://demo.default.svc:8080, 为空时通过端口转发访问新版本 Pod)
+const healthUrlKey = "P_HEALTH_URL"
+
+// 健康检查端口 环境变量 (容器端口号或端口名称, 为空时使用发布容器的第一个端口)
+const healthPortKey = "P_HEALTH_PORT"
+
+// 健康检查期望状态码 环境变量
+const healthStatusKey = "P_HEALTH_STATUS"
+
+// 健康检查期望响应内容 环境变量 (响应包含该内容视为健康)
+const healthBodyKey = "P_HEALTH_BODY"
+
+// 健康检查超时时间 环境变量 (秒 或 Duration 格式)
+const healthTimeoutKey = "P_HEALTH_TIMEOUT"
+
+// 默认健康检查超时时间
+const defaultHealthTimeout = time.Minute
+
+// 健康检查轮询间隔
+const healthPollInterval = 3 * time.Second
+
+// 健康检查单次请求超时时间
+const healthRequestTimeout = 5 * time.Second
+
+// healthCheck 健康检查配置
+type healthCheck struct {
+	Path    string        // 检查路径
+	Url     string        // 服务地址
+	Port    string        // 容器端口
+	Status  int           // 期望状态码
+	Body    string        // 期望响应内容
+	Timeout time.Duration // 超时时间
+}
+
+// readHealthCheck 读取健康检查配置, 未配置检查路径时返回 nil
+func readHealthCheck() (*healthCheck, error) {
+	path, ok := environment.Get(healthPathKey)
+	if !ok {
+		return nil, nil
+	}
+	var check = &healthCheck{
+		Path:    "/" + strings.TrimPrefix(path, "/"),
+		Status:  http.StatusOK,
+		Timeout: environmentDuration(healthTimeoutKey, defaultHealthTimeout),
+	}
+	check.Url, _ = environment.Get(healthUrlKey)
+	check.Port, _ = environment.Get(healthPortKey)
+	check.Body, _ = environment.Get(healthBodyKey)
+	if value, ok := environment.Get(healthStatusKey); ok {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Environment variable ${%s}=%s Format Error", healthStatusKey, value))
+		}
+		check.Status = status
+	}
+	return check, nil
+}
+
+// request 请求一次健康检查地址, 状态码与响应内容不符时返回错误
+func (h *healthCheck) request(url string) error {
+	var client = http.Client{Timeout: healthRequestTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != h.Status {
+		return errors.New(fmt.Sprintf("status %d (expected %d): %s", response.StatusCode, h.Status, strings.TrimSpace(string(body))))
+	}
+	if h.Body != "" && !strings.Contains(string(body), h.Body) {
+		return errors.New(fmt.Sprintf("body not contains %q: %s", h.Body, strings.TrimSpace(string(body))))
+	}
+	return nil
+}
+
+// poll 轮询健康检查地址直到健康或超过截止时间
+func (h *healthCheck) poll(name, url string, deadline time.Time) error {
+	for {
+		err := h.request(url)
+		if err == nil {
+			color.Green(fmt.Sprintf("[Kubernetes] Health Check %s %s Success", name, url))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("health check %s %s fail after %s: %s", name, url, h.Timeout, err))
+		}
+		color.Blue(fmt.Sprintf("[Kubernetes] Health Check %s %s: %s", name, url, err))
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// podPort 解析健康检查的容器端口 (端口名称按 Pod 容器端口匹配)
+func podPort(workload *Workload, pod v1.Pod, port string) (int, error) {
+	if port != "" {
+		if number, err := strconv.Atoi(port); err == nil {
+			return number, nil
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, item := range container.Ports {
+				if item.Name == port {
+					return int(item.ContainerPort), nil
+				}
+			}
+		}
+		return 0, errors.New(fmt.Sprintf("%s Pod %s Port %s Not", workload, pod.Name, port))
+	}
+	containers, err := ResolveContainers(workload, containerNames())
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range containers {
+		var container = item.container(&v1.PodTemplateSpec{Spec: pod.Spec})
+		if len(container.Ports) > 0 {
+			return int(container.Ports[0].ContainerPort), nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("%s Container Ports Empty, Set %s", workload, healthPortKey))
+}
+
+// portForward 通过 SPDY 将 Pod 端口转发到本地随机端口, 返回本地端口与停止函数
+func (k Kubernetes) portForward(pod v1.Pod, port int) (uint16, func(), error) {
+	if k.config == nil {
+		return 0, nil, errors.New(fmt.Sprintf("%s Port Forward Rest Config Empty", k.colony))
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(k.config)
+	if err != nil {
+		return 0, nil, err
+	}
+	var url = k.CoreV1().RESTClient().Post().Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward").URL()
+	var dialer = spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	var stopChan = make(chan struct{})
+	var readyChan = make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", port)},
+		stopChan, readyChan, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, err
+	}
+	var errChan = make(chan error, 1)
+	go func() {
+		errChan <- forwarder.ForwardPorts()
+	}()
+	select {
+	case <-readyChan:
+	case err = <-errChan:
+		return 0, nil, errors.New(fmt.Sprintf("port forward %s/%s:%d fail: %s", pod.Namespace, pod.Name, port, err))
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		close(stopChan)
+		return 0, nil, err
+	}
+	return ports[0].Local, func() { close(stopChan) }, nil
+}
+
+// VerifyWorkloadHealth 发布后健康检查: 配置服务地址时直接请求, 否则逐个端口转发新版本 Pod 请求, 未配置检查路径时跳过
+// (podsOnly 为 true 时忽略服务地址, 用于金丝雀与蓝绿发布: Service 流量尚未或未全部指向新版本)
+func (k Kubernetes) VerifyWorkloadHealth(workload *Workload, podsOnly bool) error {
+	check, err := readHealthCheck()
+	if err != nil || check == nil || workload.Kind == KindCronJob {
+		return err
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] Health Check %s %s (Timeout: %s) ...", workload, check.Path, check.Timeout))
+	var deadline = time.Now().Add(check.Timeout)
+	if check.Url != "" && !podsOnly {
+		err = check.poll(workload.String(), strings.TrimSuffix(check.Url, "/")+check.Path, deadline)
+	} else {
+		err = k.verifyPodsHealth(workload, check, deadline)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s -> %s %s", k.colony, workload, err))
+	}
+	return nil
+}
+
+// verifyPodsHealth 逐个端口转发新版本 Pod 进行健康检查
+func (k Kubernetes) verifyPodsHealth(workload *Workload, check *healthCheck, deadline time.Time) error {
+	current, err := k.GetWorkload(workload.Kind, workload.Namespace, workload.Name)
+	if err != nil {
+		return err
+	}
+	pods, _, err := k.rolloutPods(current)
+	if err != nil {
+		return err
+	}
+	var count = 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		port, err := podPort(current, pod, check.Port)
+		if err != nil {
+			return err
+		}
+		localPort, stop, err := k.portForward(pod, port)
+		if err != nil {
+			return err
+		}
+		err = check.poll(pod.Name, fmt.Sprintf("http://127.0.0.1:%d%s", localPort, check.Path), deadline)
+		stop()
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 {
+		return errors.New("health check no running pods")
+	}
+	return nil
+}
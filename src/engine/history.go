@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/nuwa/bpp.v3/environment"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+	"time"
+)
+
+// 发布记录注解 (JSON 数组, 保留最近的发布记录)
+const releaseHistoryAnnotation = "bpp/release-history"
+
+// 工作负载注解保留的发布记录数量
+const releaseHistoryLimit = 10
+
+// newReleaseRecord 创建发布记录 (提交版本与作者读取 GitLab 环境变量)
+func newReleaseRecord(colony, env, namespace, serviceName, strategy string) environment.ReleaseRecord {
+	commitSha, _ := environment.Get("CI_COMMIT_SHA")
+	author, _ := environment.Get("CI_COMMIT_AUTHOR")
+	return environment.ReleaseRecord{
+		Service:   serviceName,
+		Colony:    colony,
+		Env:       env,
+		Namespace: namespace,
+		Strategy:  strategy,
+		CommitSha: commitSha,
+		Author:    author,
+		Time:      time.Now().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// annotateRelease 追加发布记录到工作负载注解 (仅修改元数据 不触发发布)
+// 蓝绿发布记录到发布的颜色服务: 成功后 Service 已切换到该颜色, 失败时 Service 未切换 该颜色仍为空闲颜色
+func (k Kubernetes) annotateRelease(kind string, record environment.ReleaseRecord) error {
+	var workload *Workload
+	var err error
+	if record.Strategy == strategyBlueGreen {
+		workload, err = k.colorWorkload(record.Namespace, record.Service, record.Result != TargetSuccess)
+	} else {
+		workload, err = k.GetWorkload(kind, record.Namespace, record.Service)
+	}
+	if err != nil {
+		return err
+	}
+	var records []environment.ReleaseRecord
+	if value := workload.Object.GetAnnotations()[releaseHistoryAnnotation]; value != "" {
+		_ = json.Unmarshal([]byte(value), &records)
+	}
+	records = append(records, record)
+	if len(records) > releaseHistoryLimit {
+		records = records[len(records)-releaseHistoryLimit:]
+	}
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	requestByteData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{releaseHistoryAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = k.PatchWorkload(workload, types.MergePatchType, requestByteData)
+	return err
+}
+
+// saveRelease 保存发布记录到服务器与工作负载注解 (保存失败仅输出警告 不影响发布结果)
+func saveRelease(env, kind string, record environment.ReleaseRecord) {
+	if err := environment.SaveRelease(record); err != nil {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s / %s Save Release Record Fail: %s", record.Colony, record.Namespace, err))
+	}
+	kubernetesClient, err := targetClient(record.Colony, env, record.Namespace)
+	if err == nil {
+		err = kubernetesClient.annotateRelease(kind, record)
+	}
+	if err != nil {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s / %s Annotate Release Record Fail: %s", record.Colony, record.Namespace, err))
+	}
+}
+
+// PrintReleaseHistory 输出服务的发布记录
+func PrintReleaseHistory(serviceName string) error {
+	color.Green(fmt.Sprintf("Get Release History: %s ...", serviceName))
+	records, err := environment.ListRelease(serviceName)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for index, item := range records {
+		var commitSha = item.CommitSha
+		if len(commitSha) > 8 {
+			commitSha = commitSha[:8]
+		}
+		rows = append(rows, []string{strconv.Itoa(index + 1), item.Time, item.Colony, item.Env, item.Namespace, commitSha, item.Author,
+			item.PreviousImage, item.Image, item.Result, (time.Duration(item.Duration) * time.Millisecond).Round(time.Second).String()})
+	}
+	common.PrintTable([]string{"序号", "时间", "集群", "环境", "命名空间", "提交", "作者", "发布前镜像", "发布后镜像", "结果", "耗时"}, rows)
+	return nil
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 镜像名称配置 (每个集群的镜像改写规则)
+const imageNameConfigKey = "GL_IMAGE_NAME_CONFIG"
+
+// 镜像改写规则类型
+const (
+	imageRuleReplace    = "replace"    // 字符串替换 (from -> to, 兼容旧配置 "from->to")
+	imageRuleRegistry   = "registry"   // 仓库地址映射 (from -> to, 例如: 公网地址 -> VPC 地址)
+	imageRuleRepository = "repository" // 镜像路径改名 (from -> to, 按路径前缀匹配)
+	imageRuleTag        = "tag"        // 覆盖版本号 (tag)
+	imageRuleDigest     = "digest"     // 固定镜像摘要 (digest)
+	imageRuleRegex      = "regex"      // 正则替换 (pattern -> replace)
+)
+
+// ImageRule 镜像改写规则 (GL_IMAGE_NAME_CONFIG 中每个集群的规则按顺序执行)
+//
+//	{"ACK": "registry.cn-shanghai.aliyuncs.com->registry-vpc.cn-shanghai.aliyuncs.com"}
+//	{"ACK": [{"type": "registry", "from": "registry.cn-shanghai.aliyuncs.com", "to": "registry-vpc.cn-shanghai.aliyuncs.com"},
+//	         {"type": "tag", "env": "prod", "tag": "stable"}]}
+type ImageRule struct {
+	Type    string `json:"type"`    // 规则类型
+	Env     string `json:"env"`     // 生效的集群环境 (为空时全部环境生效)
+	From    string `json:"from"`    // 匹配内容 (replace / registry / repository)
+	To      string `json:"to"`      // 替换内容 (replace / registry / repository)
+	Tag     string `json:"tag"`     // 版本号 (tag)
+	Digest  string `json:"digest"`  // 镜像摘要 (digest)
+	Pattern string `json:"pattern"` // 正则表达式 (regex)
+	Replace string `json:"replace"` // 替换内容 (regex, 支持 $1 引用分组)
+}
+
+// String 规则描述
+func (r ImageRule) String() string {
+	var description string
+	switch r.Type {
+	case imageRuleTag:
+		description = "tag=" + r.Tag
+	case imageRuleDigest:
+		description = "digest=" + r.Digest
+	case imageRuleRegex:
+		description = r.Pattern + " -> " + r.Replace
+	default:
+		description = r.From + " -> " + r.To
+	}
+	return r.Type + "(" + description + ")" + lo.Ternary(r.Env == "", "", "@"+r.Env)
+}
+
+// ImageReference 镜像名称组成 (仓库地址/镜像路径:版本号@摘要)
+type ImageReference struct {
+	Registry   string // 仓库地址 (为空时为 Docker Hub)
+	Repository string // 镜像路径
+	Tag        string // 版本号
+	Digest     string // 镜像摘要
+}
+
+// ParseImageReference 解析镜像名称 (第一段包含 . 或 : 或为 localhost 时视为仓库地址)
+func ParseImageReference(imageName string) ImageReference {
+	var reference ImageReference
+	var name = imageName
+	if index := strings.Index(name, "@"); index >= 0 {
+		name, reference.Digest = name[:index], name[index+1:]
+	}
+	if index := strings.Index(name, "/"); index >= 0 {
+		var first = name[:index]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			reference.Registry, name = first, name[index+1:]
+		}
+	}
+	if index := strings.LastIndex(name, ":"); index >= 0 {
+		name, reference.Tag = name[:index], name[index+1:]
+	}
+	reference.Repository = name
+	return reference
+}
+
+// Name 不包含版本号与摘要的镜像名称
+func (r ImageReference) Name() string {
+	return lo.Ternary(r.Registry == "", "", r.Registry+"/") + r.Repository
+}
+
+// String 镜像名称 (存在摘要时使用摘要, 否则使用版本号)
+func (r ImageReference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + lo.Ternary(r.Tag == "", "", ":"+r.Tag)
+}
+
+// apply 执行改写规则, 返回改写后的镜像名称
+func (r ImageRule) apply(imageName string) (string, error) {
+	var reference = ParseImageReference(imageName)
+	switch r.Type {
+	case imageRuleReplace:
+		return strings.ReplaceAll(imageName, r.From, r.To), nil
+	case imageRuleRegistry:
+		if reference.Registry != r.From {
+			return imageName, nil
+		}
+		reference.Registry = r.To
+	case imageRuleRepository:
+		if reference.Repository != r.From && !strings.HasPrefix(reference.Repository, strings.TrimSuffix(r.From, "/")+"/") {
+			return imageName, nil
+		}
+		reference.Repository = r.To + strings.TrimPrefix(reference.Repository, r.From)
+	case imageRuleTag:
+		reference.Tag, reference.Digest = r.Tag, ""
+	case imageRuleDigest:
+		reference.Digest = r.Digest
+	case imageRuleRegex:
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Image Rule %s Pattern Error: %s", r, err))
+		}
+		return pattern.ReplaceAllString(imageName, r.Replace), nil
+	default:
+		return "", errors.New(fmt.Sprintf("Image Rule Type %s Not Support (%s)", r.Type, strings.Join([]string{imageRuleReplace,
+			imageRuleRegistry, imageRuleRepository, imageRuleTag, imageRuleDigest, imageRuleRegex}, " / ")))
+	}
+	return reference.String(), nil
+}
+
+// imageRules 读取全部集群的镜像改写规则 (集群名称转为大写)
+func imageRules() (map[string][]ImageRule, error) {
+	var result = map[string][]ImageRule{}
+	configValue, ok := environment.Get(imageNameConfigKey)
+	if !ok {
+		return result, nil
+	}
+	var config map[string]json.RawMessage
+	err := json.Unmarshal([]byte(configValue), &config)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range config {
+		var rules []ImageRule
+		var legacy string
+		if err := json.Unmarshal(value, &legacy); err == nil {
+			// 旧配置: "from->to"
+			var values = strings.Split(legacy, "->")
+			if len(values) >= 2 {
+				rules = append(rules, ImageRule{Type: imageRuleReplace, From: values[0], To: values[1]})
+			}
+		} else if err := json.Unmarshal(value, &rules); err != nil {
+			return nil, errors.New(fmt.Sprintf("Environment variable ${%s} Colony %s Rules Format Error: %s", imageNameConfigKey, key, err))
+		}
+		result[strings.ToUpper(key)] = append(result[strings.ToUpper(key)], rules...)
+	}
+	return result, nil
+}
+
+// ResolveImageName 按集群与集群环境的改写规则解析镜像名称, 返回改写后的镜像名称与生效的规则
+func ResolveImageName(colony, env, imageName string) (string, []ImageRule, error) {
+	rules, err := imageRules()
+	if err != nil {
+		return "", nil, err
+	}
+	var applied []ImageRule
+	for _, rule := range rules[strings.ToUpper(colony)] {
+		if rule.Env != "" && !strings.EqualFold(rule.Env, env) {
+			continue
+		}
+		newImageName, err := rule.apply(imageName)
+		if err != nil {
+			return "", nil, err
+		}
+		if newImageName != imageName {
+			applied = append(applied, rule)
+			imageName = newImageName
+		}
+	}
+	return imageName, applied, nil
+}
+
+// PrintImageResolve 输出镜像名称在每个集群的改写结果 (集群为指定集群与改写规则中配置的集群)
+func PrintImageResolve(colony, env, imageName string) error {
+	color.Green(fmt.Sprintf("Resolve Image: %s Env: %s ...", imageName, lo.Ternary(env == "", "-", env)))
+	rules, err := imageRules()
+	if err != nil {
+		return err
+	}
+	var colonies = lo.Keys(rules)
+	if colony != "" {
+		colonies = append(colonies, strings.Split(strings.ToUpper(colony), ",")...)
+	}
+	colonies = lo.Uniq(colonies)
+	sort.Strings(colonies)
+	var rows [][]string
+	for _, item := range colonies {
+		newImageName, applied, err := ResolveImageName(item, env, imageName)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []string{item, lo.Ternary(env == "", "-", env), imageName, newImageName,
+			strings.Join(lo.Map(applied, func(rule ImageRule, _ int) string { return rule.String() }), "; ")})
+	}
+	common.PrintTable([]string{"集群", "环境", "原镜像", "发布镜像", "生效规则"}, rows)
+	return nil
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sort"
+	"strings"
+)
+
+// 集群上下文名称 环境变量前缀 (支持 _<COLONY>_<ENV> / _<COLONY> 后缀, 为空时使用 current-context)
+const colonyContextKeyPrefix = "P_KUBERNETES_CONTEXT"
+
+// 集群认证方式 (集群配置为 JSON 且包含 mode 字段时生效, 否则按 kubeconfig 解析)
+const (
+	authModeToken     = "token"     // 令牌认证
+	authModeExec      = "exec"      // 外部命令认证 (client.authentication.k8s.io)
+	authModeInCluster = "inCluster" // 集群内 ServiceAccount 认证
+)
+
+// 默认外部命令认证 API 版本
+const defaultExecApiVersion = "client.authentication.k8s.io/v1beta1"
+
+// colonyAuthConfig JSON 格式的集群认证配置
+type colonyAuthConfig struct {
+	Mode                     string            `json:"mode"`                     // 认证方式
+	Server                   string            `json:"server"`                   // API Server 地址
+	Token                    string            `json:"token"`                    // 令牌 (token)
+	CertificateAuthorityData string            `json:"certificateAuthorityData"` // CA 证书 (Base64 或 PEM)
+	Insecure                 bool              `json:"insecure"`                 // 跳过证书校验
+	Command                  string            `json:"command"`                  // 认证命令 (exec)
+	Args                     []string          `json:"args"`                     // 认证命令参数 (exec)
+	Env                      map[string]string `json:"env"`                      // 认证命令环境变量 (exec)
+	ApiVersion               string            `json:"apiVersion"`               // 认证命令 API 版本 (exec)
+}
+
+// colonyContext 读取集群上下文名称
+func colonyContext(colony, env string) string {
+	for _, key := range []string{
+		colonyContextKeyPrefix + "_" + colony + "_" + strings.ToUpper(env),
+		colonyContextKeyPrefix + "_" + colony,
+		colonyContextKeyPrefix,
+	} {
+		if value, ok := environment.Get(key); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// RestConfig 基于内存中的集群配置创建客户端配置 (不写出任何文件)
+func RestConfig(colony, env, configContent string) (*rest.Config, error) {
+	var content = strings.TrimSpace(configContent)
+	if strings.HasPrefix(content, "{") {
+		var auth colonyAuthConfig
+		if err := json.Unmarshal([]byte(content), &auth); err == nil && auth.Mode != "" {
+			return authRestConfig(auth)
+		}
+	}
+	var contextName = colonyContext(colony, env)
+	if contextName == "" {
+		return clientcmd.RESTConfigFromKubeConfig([]byte(configContent))
+	}
+	config, err := clientcmd.Load([]byte(configContent))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config.Contexts[contextName]; !ok {
+		var contexts = lo.Keys(config.Contexts)
+		sort.Strings(contexts)
+		return nil, errors.New(fmt.Sprintf("Kubernetes colony %s Context %s Not (Contexts: %s)", colony, contextName, strings.Join(contexts, ", ")))
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
+
+// authRestConfig 基于 JSON 认证配置创建客户端配置
+func authRestConfig(auth colonyAuthConfig) (*rest.Config, error) {
+	if auth.Mode == authModeInCluster {
+		return rest.InClusterConfig()
+	}
+	if auth.Server == "" {
+		return nil, errors.New(fmt.Sprintf("Kubernetes Auth Mode %s Server Is Empty", auth.Mode))
+	}
+	var config = &rest.Config{Host: auth.Server}
+	config.TLSClientConfig.Insecure = auth.Insecure
+	// 跳过证书校验时不能同时指定 CA 证书 (client-go 拒绝该组合)
+	if auth.Insecure && auth.CertificateAuthorityData != "" {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Insecure, Ignore certificateAuthorityData", auth.Server))
+	} else if auth.CertificateAuthorityData != "" {
+		config.TLSClientConfig.CAData = []byte(auth.CertificateAuthorityData)
+		if decoded, err := base64.StdEncoding.DecodeString(auth.CertificateAuthorityData); err == nil {
+			config.TLSClientConfig.CAData = decoded
+		}
+	}
+	switch auth.Mode {
+	case authModeToken:
+		if auth.Token == "" {
+			return nil, errors.New("Kubernetes Auth Mode token Token Is Empty")
+		}
+		config.BearerToken = auth.Token
+	case authModeExec:
+		if auth.Command == "" {
+			return nil, errors.New("Kubernetes Auth Mode exec Command Is Empty")
+		}
+		config.ExecProvider = &clientcmdapi.ExecConfig{
+			Command:         auth.Command,
+			Args:            auth.Args,
+			APIVersion:      lo.Ternary(auth.ApiVersion == "", defaultExecApiVersion, auth.ApiVersion),
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+			Env: lo.MapToSlice(auth.Env, func(key string, value string) clientcmdapi.ExecEnvVar {
+				return clientcmdapi.ExecEnvVar{Name: key, Value: value}
+			}),
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Kubernetes Auth Mode %s Not Support (%s / %s / %s)",
+			auth.Mode, authModeToken, authModeExec, authModeInCluster))
+	}
+	return config, nil
+}
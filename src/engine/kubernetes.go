@@ -2,75 +2,77 @@ package engine
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
 	"github.com/nuwa/bpp.v3/environment"
 	"github.com/samber/lo"
 	v12 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 // 集群配置文件 环境变量前缀
 const colonyKeyPrefix = "GS_RELEASE_KUBERNETES_"
 
-// 镜像名称配置
-const imageNameConfigKey = "GL_IMAGE_NAME_CONFIG"
-
 // 镜像拉取策略 始终拉取最新
 const imagePullPolicyAlways = "Always"
 
-// Pod 标签选择器
-const (
-	deploymentDefaultLabelSelectKey = "app"        // 默认 Kubernetes 集群
-	deploymentK8SLabelSelectKey     = "k8s-app"    // 阿里云 Kubernetes 集群
-	deploymentTencentLabelSelectKey = "qcloud-app" // 腾讯云 Kubernetes 集群
-)
-
 type Kubernetes struct {
 	*kubernetes.Clientset
-	colony string // 集群名称
+	config *rest.Config // 客户端配置
+	colony string       // 集群名称
+	dryRun bool         // 试运行 (服务端校验修改 不会持久化)
 }
 
-// NewConfigClient 基于内存文件创建 Kubernetes 客户端
+// DryRun 返回试运行模式的客户端
+func (k Kubernetes) DryRun() *Kubernetes {
+	k.dryRun = true
+	return &k
+}
+
+// NewConfigClient 基于内存中的集群配置创建 Kubernetes 客户端 (kubeconfig 或 JSON 认证配置)
 func NewConfigClient(colony, env, configContent string) (*Kubernetes, error) {
-	// 写出配置文件
-	var configPath = colony + "-" + env + ".yaml"
-	err := os.WriteFile(configPath, []byte(configContent), 644)
+	config, err := RestConfig(colony, env, configContent)
 	if err != nil {
 		return nil, err
 	}
-	return NewKubernetesClient(colony, configPath)
+	return newKubernetes(colony, config)
 }
 
-// NewKubernetesClient 创建 Kubernetes 客户端
+// NewKubernetesClient 基于配置文件创建 Kubernetes 客户端
 func NewKubernetesClient(colony, configPath string) (*Kubernetes, error) {
 	// 加载配置文件
 	config, err := clientcmd.BuildConfigFromFlags("", configPath)
 	if err != nil {
 		return nil, err
 	}
+	return newKubernetes(colony, config)
+}
+
+// newKubernetes 创建 Kubernetes 客户端
+func newKubernetes(colony string, config *rest.Config) (*Kubernetes, error) {
 	restClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
-	return &Kubernetes{Clientset: restClient, colony: colony}, nil
+	return &Kubernetes{Clientset: restClient, config: config, colony: colony}, nil
 }
 
 // ListNamespace 查询所有命名空间
-func (k Kubernetes) ListNamespace() []v1.Namespace {
+func (k Kubernetes) ListNamespace() ([]v1.Namespace, error) {
 	list, err := k.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return list.Items
+	return list.Items, nil
 }
 
 // Deployments 查询所有的无状态服务
@@ -96,188 +98,278 @@ func (k Kubernetes) ListPod(namespace, selector string) []v1.Pod {
 // DeletePod 根据名称删除Pod
 func (k Kubernetes) DeletePod(namespace, podName string) (bool, error) {
 	color.Green(fmt.Sprintf("[Kubernetes] Delete %s Pods : %s", namespace, podName))
-	err := k.CoreV1().Pods(namespace).Delete(context.Background(), podName, metav1.DeleteOptions{})
+	var options = metav1.DeleteOptions{}
+	if k.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	err := k.CoreV1().Pods(namespace).Delete(context.Background(), podName, options)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-// UpdateDeploymentImage 更新无状态服务的镜像版本
-func (k Kubernetes) UpdateDeploymentImage(namespace, deploymentName, imageName string) (bool, error) {
-	color.Green(fmt.Sprintf("[Kubernetes] Update Deployment Image : %s/%s <- %s", namespace, deploymentName, imageName))
-	var item = map[string]interface{}{}
-	item["op"] = "replace"
-	item["path"] = "/spec/template/spec/containers/0/image"
-	item["value"] = imageName
-	requestByteData, err := json.Marshal([]map[string]interface{}{item})
+// ReleaseService 发布服务 (目标容器由 P_CONTAINER_NAME 指定), 返回发布后的工作负载
+func (k Kubernetes) ReleaseService(workload *Workload, newImageName string) (*Workload, error) {
+	containers, err := ResolveContainers(workload, containerNames())
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	_, err = k.AppsV1().Deployments(namespace).Patch(context.Background(), deploymentName,
-		types.JSONPatchType, requestByteData, metav1.PatchOptions{})
+	// 检查服务配置是是总是拉取
+	var pullPolicyContainers = lo.Filter(containers, func(item ContainerTarget, _ int) bool {
+		return item.container(workload.Template).ImagePullPolicy != imagePullPolicyAlways
+	})
+	var operations = containerOperations(pullPolicyContainers, "imagePullPolicy", imagePullPolicyAlways)
+	// 如果镜像相同则重启服务 否则 修改服务镜像版本
+	var sameImage = true
+	for _, item := range containers {
+		var container = item.container(workload.Template)
+		color.Blue(fmt.Sprintf("%s ImageName: %s -> %s", workload.Kind, container.Name, container.Image))
+		sameImage = sameImage && container.Image == newImageName
+	}
+	if sameImage {
+		color.Blue("Rolling Restart Pods  ...")
+		operations = append(operations, restartOperation(workload))
+	} else {
+		color.Blue(fmt.Sprintf("%s Image  ...", workload.Kind))
+		if workload.partition() > 0 {
+			color.Yellow(fmt.Sprintf("[Kubernetes] %s Partition: %d, Only Ordinal >= %d Pods Will Be Updated",
+				workload, workload.partition(), workload.partition()))
+		}
+		operations = append(operations, containerOperations(containers, "image", newImageName)...)
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Release %s [%s] <- %s%s", workload, strings.Join(lo.Map(containers,
+		func(item ContainerTarget, _ int) string { return item.Name }), ", "), newImageName, lo.Ternary(k.dryRun, " (Dry Run)", "")))
+	released, err := k.patchWorkloadTemplate(workload, operations...)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	// 更新策略为 OnDelete 时需要逐个删除 Pod
+	if workload.onDelete() {
+		if k.dryRun {
+			color.Yellow(fmt.Sprintf("[Kubernetes] %s OnDelete Strategy, Pods Will Be Recreated One By One", workload))
+			return released, nil
+		}
+		return released, k.recreateWorkloadPods(workload, releaseTimeout())
+	}
+	return released, nil
+}
+
+// printReleaseDiff 输出发布前后 Pod 模板中镜像、拉取策略以及重启注解的变化
+func printReleaseDiff(colony string, before, after *Workload) {
+	var rows [][]string
+	var appendRow = func(container, field, beforeValue, afterValue string) {
+		if beforeValue != afterValue {
+			rows = append(rows, []string{colony, before.Namespace, before.Kind + "/" + before.Name, container, field, beforeValue, afterValue})
+		}
+	}
+	var beforeContainers = append(append([]v1.Container{}, before.Template.Spec.InitContainers...), before.Template.Spec.Containers...)
+	var afterContainers = append(append([]v1.Container{}, after.Template.Spec.InitContainers...), after.Template.Spec.Containers...)
+	for i := range beforeContainers {
+		if i >= len(afterContainers) {
+			break
+		}
+		appendRow(beforeContainers[i].Name, "image", beforeContainers[i].Image, afterContainers[i].Image)
+		appendRow(beforeContainers[i].Name, "imagePullPolicy", string(beforeContainers[i].ImagePullPolicy), string(afterContainers[i].ImagePullPolicy))
+	}
+	appendRow("-", "annotations."+restartedAtAnnotation, before.Template.Annotations[restartedAtAnnotation], after.Template.Annotations[restartedAtAnnotation])
+	color.Cyan(fmt.Sprintf("[Kubernetes] %s -> %s Dry Run Diff:", colony, before))
+	common.PrintTable([]string{"集群", "命名空间", "工作负载", "容器", "字段", "发布前", "发布后"}, rows)
 }
 
-// UpdateDeploymentImagePullPolicyAlways 更新无状态服务的镜像拉取策略为始终拉取最新
-func (k Kubernetes) UpdateDeploymentImagePullPolicyAlways(namespace, deploymentName string) (bool, error) {
-	var item = map[string]interface{}{}
-	item["op"] = "replace"
-	item["path"] = "/spec/template/spec/containers/0/imagePullPolicy"
-	item["value"] = imagePullPolicyAlways
-	requestByteData, err := json.Marshal([]map[string]interface{}{item})
+// colonyConfig 读取集群配置文件 (优先读取集群环境配置)
+func colonyConfig(colony, env string) (*string, error) {
+	value, ok := environment.Get(colonyKeyPrefix + colony + "_" + strings.ToUpper(env))
+	if ok {
+		return &value, nil
+	}
+	value, ok = environment.Get(colonyKeyPrefix + colony)
+	if ok {
+		return &value, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Kubernetes colony Config (%s or %s) Find Not",
+		colonyKeyPrefix+colony+"_"+strings.ToUpper(env), colonyKeyPrefix+colony))
+}
+
+// colonyClients 集群客户端缓存 (同一集群的多个命名空间共用)
+var colonyClients = map[string]*Kubernetes{}
+var colonyClientsLock sync.Mutex
+
+// colonyClient 初始化集群客户端
+func colonyClient(colony, env string) (*Kubernetes, error) {
+	colonyClientsLock.Lock()
+	defer colonyClientsLock.Unlock()
+	if kubernetesClient, ok := colonyClients[colony+"-"+env]; ok {
+		return kubernetesClient, nil
+	}
+	kubernetesConfig, err := colonyConfig(colony, env)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	_, err = k.AppsV1().Deployments(namespace).Patch(context.Background(), deploymentName,
-		types.JSONPatchType, requestByteData, metav1.PatchOptions{})
+	kubernetesClient, err := NewConfigClient(colony, env, *kubernetesConfig)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	colonyClients[colony+"-"+env] = kubernetesClient
+	return kubernetesClient, nil
 }
 
-// ReleaseService 发布服务
-func (k Kubernetes) ReleaseService(namespace, serviceName, newImageName string) error {
-	// 读取服务是否存在
-	deploymentInfo := k.Deployments(namespace, serviceName)
-	if deploymentInfo == nil {
-		return errors.New(fmt.Sprintf("%s -> %s/%s Service Not", k.colony, namespace, serviceName))
+// targetClient 初始化集群客户端, 命名空间不存在时返回 skipError
+func targetClient(colony, env, namespace string) (*Kubernetes, error) {
+	if colony == "" || namespace == "" {
+		return nil, errors.New("colony or namespace is empty")
 	}
-	// 检查服务配置是是总是拉取
-	if deploymentInfo.Spec.Template.Spec.Containers[0].ImagePullPolicy != imagePullPolicyAlways {
-		_, err := k.UpdateDeploymentImagePullPolicyAlways(namespace, serviceName)
-		if err != nil {
-			return err
-		}
+
+	// 初始化客户端
+	kubernetesClient, err := colonyClient(colony, env)
+	if err != nil {
+		return nil, err
 	}
-	// 如果镜像相同则删除POD 否则 修改服务镜像版本
-	color.Blue(fmt.Sprintf("Deployment ImageName: %s", deploymentInfo.Spec.Template.Spec.Containers[0].Image))
-	if deploymentInfo.Spec.Template.Spec.Containers[0].Image == newImageName {
-		color.Blue("Update Pods Image  ...")
-		pods := k.ListPod(namespace, deploymentDefaultLabelSelectKey+"="+serviceName)
-		// 官方
-		if pods == nil || len(pods) == 0 {
-			pods = k.ListPod(namespace, deploymentK8SLabelSelectKey+"="+serviceName)
-		}
-		// 腾讯云
-		if pods == nil || len(pods) == 0 {
-			pods = k.ListPod(namespace, deploymentTencentLabelSelectKey+"="+serviceName)
-		}
-		// 如果找不到 Pod
-		if pods == nil || len(pods) == 0 {
-			return errors.New(fmt.Sprintf("%s -> %s/%s Pod Not", k.colony, namespace, serviceName))
-		}
-		for _, pod := range pods {
-			_, err := k.DeletePod(namespace, pod.Name)
-			if err != nil {
-				return err
-			}
-		}
-	} else {
-		color.Blue("Deployment Image  ...")
-		_, err := k.UpdateDeploymentImage(namespace, serviceName, newImageName)
-		if err != nil {
-			return err
-		}
+
+	// 读取命名空间是否存在 (集群无法访问或无权限时视为失败)
+	namespaces, err := kubernetesClient.ListNamespace()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s-%s List Namespace Fail: %s", colony, env, err))
 	}
-	return nil
+	_, namespaceExist := lo.Find(namespaces, func(item v1.Namespace) bool {
+		return item.Name == namespace
+	})
+	if !namespaceExist {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s-%s -> %s Namespace Not", colony, env, namespace))
+		return nil, skipError{message: fmt.Sprintf("Namespace %s Not", namespace)}
+	}
+	return kubernetesClient, nil
 }
 
-// parseImageName 解析镜像名称
-func parseImageName(colony, imageName string) (*string, error) {
-	colony = strings.ToUpper(colony)
-	if configValue, ok := environment.Get(imageNameConfigKey); ok {
-		var config map[string]interface{}
-		err := json.Unmarshal([]byte(configValue), &config)
-		if err != nil {
-			return nil, err
-		}
-		for key, value := range config {
-			if strings.ToUpper(key) == colony {
-				var values = strings.Split(fmt.Sprint(value), "->")
-				if len(values) < 2 {
-					continue
-				}
-				var newImageName = strings.ReplaceAll(imageName, values[0], values[1])
-				return &newImageName, err
-			}
-		}
+// ExecuteReleaseService 执行发布服务, dryRun 为 true 时仅由服务端试运行并输出变化.
+func ExecuteReleaseService(colony, env, namespace, serviceName, imageName string, dryRun bool) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 镜像名称: %s 试运行: %t", colony, env, namespace, serviceName, imageName, dryRun))
+	kind, err := workloadKind()
+	if err != nil {
+		return err
 	}
-	return &imageName, nil
+	strategy, err := releaseStrategy()
+	if err != nil {
+		return err
+	}
+	// 固定镜像摘要 (按集群改写后的镜像分别解析)
+	digests, err := releaseImageDigests()
+	if err != nil {
+		return err
+	}
+	return eachTarget(colony, namespace, func(colony, namespace string) error {
+		var start = time.Now()
+		var record = newReleaseRecord(colony, env, namespace, serviceName, strategy)
+		err := releaseTarget(colony, env, namespace, serviceName, imageName, kind, strategy, dryRun, digests, &record)
+		var skip skipError
+		if dryRun || record.Image == "" || errors.As(err, &skip) {
+			return err
+		}
+		// 记录发布结果
+		record.Result = lo.Ternary(err == nil, TargetSuccess, TargetFailed)
+		record.Message = lo.TernaryF(err == nil, func() string { return "" }, func() string { return err.Error() })
+		record.Duration = time.Since(start).Milliseconds()
+		saveRelease(env, kind, record)
+		return err
+	})
 }
 
-// ExecuteReleaseService 执行发布服务.
-func ExecuteReleaseService(colony, env, namespace, serviceName, imageName string) error {
-	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 镜像名称: %s", colony, env, namespace, serviceName, imageName))
-	var actuator = func(f func(colony, namespace string) error) error {
-		for _, c := range strings.Split(colony, ",") {
-			for _, n := range strings.Split(namespace, ",") {
-				var err = f(strings.ToUpper(c), n)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return nil
+// releaseTarget 发布单个 集群 x 命名空间 目标 (digests 不为 nil 时固定镜像摘要), 发布的镜像记录到 record
+func releaseTarget(colony, env, namespace, serviceName, imageName, kind, strategy string, dryRun bool, digests *ImageDigests, record *environment.ReleaseRecord) error {
+	// 解析镜像名称
+	newImageName, err := digests.Resolve(colony, env, imageName)
+	if err != nil {
+		return err
+	}
+	record.Image = newImageName
+
+	color.Green(fmt.Sprintf("Release to Kubernetes (%s) %s / %s <-- %s",
+		colony, serviceName, namespace, newImageName))
+
+	kubernetesClient, err := targetClient(colony, env, namespace)
+	if err != nil {
+		return err
 	}
-	return actuator(func(colony, namespace string) error {
-		if colony == "" || namespace == "" {
-			return errors.New("colony or namespace is empty")
-		}
 
-		kubernetesConfig, err := func() (*string, error) {
-			value, ok := environment.Get(colonyKeyPrefix + colony + "_" + strings.ToUpper(env))
-			if ok {
-				return &value, nil
-			}
-			value, ok = environment.Get(colonyKeyPrefix + colony)
-			if ok {
-				return &value, nil
-			}
-			return nil, errors.New(fmt.Sprintf("Kubernetes colony Config (%s or %s) Find Not",
-				colonyKeyPrefix+colony+"_"+strings.ToUpper(env), colonyKeyPrefix+colony))
-		}()
+	// 蓝绿发布 (服务名称为 Service 名称, 发布前镜像为 Service 当前颜色的服务)
+	if strategy == strategyBlueGreen {
+		workload, err := kubernetesClient.colorWorkload(namespace, serviceName, false)
 		if err != nil {
 			return err
 		}
+		record.PreviousImage = templateImages(workload.Template)
+		return lo.Ternary(dryRun, kubernetesClient.DryRun(), kubernetesClient).ReleaseBlueGreen(namespace, serviceName, newImageName)
+	}
+
+	// 读取服务是否存在
+	workload, err := kubernetesClient.GetWorkload(kind, namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	record.PreviousImage = templateImages(workload.Template)
 
-		// 解析镜像名称
-		newImageName, err := parseImageName(colony, imageName)
+	// 金丝雀发布
+	if strategy == strategyCanary {
+		return lo.Ternary(dryRun, kubernetesClient.DryRun(), kubernetesClient).ReleaseCanary(workload, newImageName)
+	}
+
+	// 记录发布前的 Pod 模板 用于失败回滚
+	var previousTemplate = workload.Template.DeepCopy()
+
+	// 试运行 仅输出变化
+	if dryRun {
+		released, err := kubernetesClient.DryRun().ReleaseService(workload, newImageName)
 		if err != nil {
 			return err
 		}
-		color.Green(fmt.Sprintf("Release to Kubernetes (%s) %s / %s <-- %s",
-			colony, serviceName, namespace, *newImageName))
+		printReleaseDiff(colony, workload, released)
+		return nil
+	}
+
+	// 刷新服务
+	_, err = kubernetesClient.ReleaseService(workload, newImageName)
+	if err == nil {
+		// 等待发布完成
+		err = kubernetesClient.WaitWorkloadRollout(workload, releaseTimeout())
+	}
+	if err == nil {
+		// 发布后健康检查
+		err = kubernetesClient.VerifyWorkloadHealth(workload, false)
+	}
+	if err != nil && autoRollback() {
+		return kubernetesClient.rollbackRelease(workload, previousTemplate, err)
+	}
+	return err
+}
 
-		// 初始化客户端
-		kubernetesClient, err := NewConfigClient(colony, env, *kubernetesConfig)
+// ExecuteRollbackService 执行回滚服务到指定版本 (revision 为 0 时回滚到上一个版本).
+func ExecuteRollbackService(colony, env, namespace, serviceName string, revision int64) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 回滚版本: %d", colony, env, namespace, serviceName, revision))
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	strategy, err := releaseStrategy()
+	if err != nil {
+		return err
+	}
+	return eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
 		if err != nil {
 			return err
 		}
-
-		// 读取命名空间是否存在
-		namespaces := kubernetesClient.ListNamespace()
-		if namespaces == nil {
-			color.Yellow(fmt.Sprintf("[Kubernetes] 集群: %s 命名空间为空", colony))
-			return nil
+		// 蓝绿发布 切换 Service 到另一种颜色
+		if strategy == strategyBlueGreen {
+			return kubernetesClient.RollbackBlueGreen(namespace, serviceName)
 		}
-		_, namespaceExist := lo.Find(namespaces, func(item v1.Namespace) bool {
-			return item.Name == namespace
-		})
-		if !namespaceExist {
-			color.Yellow(fmt.Sprintf("[Kubernetes] %s-%s -> %s Namespace Not", colony, env, namespace))
-			return nil
+		workload, err := kubernetesClient.GetWorkload(kind, namespace, serviceName)
+		if err != nil {
+			return err
 		}
-
-		// 刷新服务
-		err = kubernetesClient.ReleaseService(namespace, serviceName, *newImageName)
+		err = kubernetesClient.RollbackWorkload(workload, revision)
 		if err != nil {
 			return err
 		}
-		return nil
+		return kubernetesClient.WaitWorkloadRollout(workload, releaseTimeout())
 	})
 }
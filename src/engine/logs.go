@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志单行最大长度
+const logLineLimit = 1024 * 1024
+
+// LogOptions 日志查询选项
+type LogOptions struct {
+	Container string        // 容器名称 (为空时查询全部容器)
+	Since     time.Duration // 查询最近时长的日志 (为 0 时不限制)
+	Follow    bool          // 持续输出
+	Tail      int64         // 每个容器输出的最近行数 (小于 0 时不限制)
+}
+
+// podLogOptions 转换为 Pod 日志查询选项
+func (o LogOptions) podLogOptions(container string) *v1.PodLogOptions {
+	var options = &v1.PodLogOptions{Container: container, Follow: o.Follow}
+	if o.Since > 0 {
+		options.SinceSeconds = lo.ToPtr(int64(o.Since.Seconds()))
+	}
+	if o.Tail >= 0 {
+		options.TailLines = lo.ToPtr(o.Tail)
+	}
+	return options
+}
+
+// podLogSource 日志来源 (集群 x Pod x 容器)
+type podLogSource struct {
+	client    *Kubernetes
+	pod       v1.Pod
+	container string
+}
+
+// String 日志行前缀
+func (s podLogSource) String() string {
+	return fmt.Sprintf("[%s %s/%s]", s.client.colony, s.pod.Name, s.container)
+}
+
+// streamLogs 读取日志来源并逐行输出
+func (s podLogSource) streamLogs(options LogOptions, output func(source podLogSource, line string)) error {
+	stream, err := s.client.CoreV1().Pods(s.pod.Namespace).GetLogs(s.pod.Name, options.podLogOptions(s.container)).Stream(context.Background())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	var scanner = bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), logLineLimit)
+	for scanner.Scan() {
+		output(s, scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// logSources 查询服务全部 Pod 的日志来源 (按容器名称过滤)
+func (k Kubernetes) logSources(kind, namespace, serviceName, container string) ([]podLogSource, error) {
+	workload, err := k.GetWorkload(kind, namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k.PodsOf(workload)
+	if err != nil {
+		return nil, err
+	}
+	var sources []podLogSource
+	for _, pod := range pods {
+		for _, item := range pod.Spec.Containers {
+			if container == "" || item.Name == container {
+				sources = append(sources, podLogSource{client: &k, pod: pod, container: item.Name})
+			}
+		}
+	}
+	return sources, nil
+}
+
+// ExecuteServiceLogs 输出服务在全部 集群 x 命名空间 的 Pod 日志, 每行以 集群 Pod/容器 标记
+func ExecuteServiceLogs(colony, env, namespace, serviceName string, options LogOptions) error {
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 日志 (容器: %s 时长: %s 行数: %d 持续输出: %t)",
+		colony, env, namespace, serviceName, lo.Ternary(options.Container == "", "-", options.Container), options.Since, options.Tail, options.Follow))
+	var lock sync.Mutex
+	var sources []podLogSource
+	results := runTargets(colony, namespace, releaseParallelism(), false, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		items, err := kubernetesClient.logSources(kind, namespace, serviceName, options.Container)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		sources = append(sources, items...)
+		lock.Unlock()
+		return nil
+	})
+	// 失败的目标已逐个输出, 其余目标的日志照常输出, 最后返回错误
+	var failed = lo.CountBy(results, func(item TargetResult) bool {
+		return item.Status == TargetFailed
+	})
+	var failedErr error
+	if failed > 0 {
+		failedErr = errors.New(fmt.Sprintf("[Kubernetes] %d of %d targets failed", failed, len(results)))
+	}
+	if len(sources) == 0 {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s No Pods", serviceName))
+		return failedErr
+	}
+
+	// 并发读取全部日志来源, 按行加锁输出避免交错
+	var waitGroup sync.WaitGroup
+	var output = func(source podLogSource, line string) {
+		lock.Lock()
+		defer lock.Unlock()
+		fmt.Println(color.CyanString(source.String()), line)
+	}
+	for _, source := range sources {
+		waitGroup.Add(1)
+		go func(source podLogSource) {
+			defer waitGroup.Done()
+			if err := source.streamLogs(options, output); err != nil {
+				color.Yellow(fmt.Sprintf("[Kubernetes] %s Logs Fail: %s", source, err))
+			}
+		}(source)
+	}
+	waitGroup.Wait()
+	return failedErr
+}
+
+// WorkloadEvents 查询工作负载及其副本集、Pod 的事件 (包括已删除的 Pod)
+func (k Kubernetes) WorkloadEvents(workload *Workload) ([]v1.Event, error) {
+	var names = []string{workload.Name}
+	var prefixes []string
+	if deployment, ok := workload.Object.(*v12.Deployment); ok {
+		selector, err := labelSelector(deployment.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		list, err := k.AppsV1().ReplicaSets(workload.Namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			if metav1.IsControlledBy(&list.Items[i], deployment) {
+				names = append(names, list.Items[i].Name)
+				prefixes = append(prefixes, list.Items[i].Name+"-")
+			}
+		}
+	}
+	pods, err := k.PodsOf(workload)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, lo.Map(pods, func(item v1.Pod, _ int) string { return item.Name })...)
+	return k.namespaceEvents(workload.Namespace, func(item v1.Event) bool {
+		return lo.Contains(names, item.InvolvedObject.Name) || (item.InvolvedObject.Kind == "Pod" &&
+			lo.ContainsBy(prefixes, func(prefix string) bool { return strings.HasPrefix(item.InvolvedObject.Name, prefix) }))
+	})
+}
+
+// ExecuteServiceEvents 输出服务在全部 集群 x 命名空间 的事件
+func ExecuteServiceEvents(colony, env, namespace, serviceName string) error {
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 事件", colony, env, namespace, serviceName))
+	var lock sync.Mutex
+	return eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		workload, err := kubernetesClient.GetWorkload(kind, namespace, serviceName)
+		if err != nil {
+			return err
+		}
+		events, err := kubernetesClient.WorkloadEvents(workload)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		color.Cyan(fmt.Sprintf("[Kubernetes] %s -> %s Events:", colony, workload))
+		printEvents(events)
+		return nil
+	})
+}
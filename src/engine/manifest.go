@@ -0,0 +1,458 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"io"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 清单应用删除已移除对象 环境变量 (true / false)
+const manifestPruneKey = "P_MANIFEST_PRUNE"
+
+// 清单应用删除已移除的集群级别对象 环境变量 (true / false, 记录保存在命名空间中, 默认不删除集群级别对象)
+const manifestPruneClusterKey = "P_MANIFEST_PRUNE_CLUSTER"
+
+// 服务端应用字段管理者名称
+const manifestFieldManager = "bpp"
+
+// 清单记录 ConfigMap 名称前缀 (记录上次应用的对象, 用于删除已移除的对象)
+const manifestInventoryPrefix = "bpp-manifest-"
+
+// 清单记录 ConfigMap 数据键
+const manifestInventoryKey = "objects"
+
+// 清单文件扩展名
+var manifestExtensions = []string{".yaml", ".yml", ".json"}
+
+// 清单变量 ${NAME} ($${NAME} 转义为 ${NAME})
+var manifestVariablePattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// 清单变量可读取的环境变量前缀 (避免 GS_ / GL_ 等密钥写入清单)
+var manifestEnvironmentPrefixes = []string{"P_", "CI_"}
+
+// 清单对象应用顺序 (未列出的类型最后应用)
+var manifestKindOrder = []string{
+	"Namespace", "CustomResourceDefinition", "ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding",
+	"Secret", "ConfigMap", "PersistentVolumeClaim", "Service",
+}
+
+// 清单操作
+const (
+	manifestApply  = "Apply"
+	manifestPrune  = "Prune"
+	manifestRetain = "Retain"
+)
+
+// manifestFile 清单文件
+type manifestFile struct {
+	Name    string // 文件名称
+	Content string // 文件内容
+	Overlay bool   // 是否为环境覆盖文件
+}
+
+// manifestReference 清单对象引用
+type manifestReference struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// String 对象描述
+func (r manifestReference) String() string {
+	return lo.Ternary(r.Namespace == "", "", r.Namespace+"/") + r.Kind + "/" + r.Name
+}
+
+// readManifestFiles 读取清单目录中的清单文件, 以及 <目录>/<colonyEnv> 中的环境覆盖文件
+func readManifestFiles(rootPath, env string) ([]manifestFile, error) {
+	var files []manifestFile
+	for _, item := range []struct {
+		directory string
+		overlay   bool
+	}{{rootPath, false}, {filepath.Join(rootPath, env), true}} {
+		entries, err := os.ReadDir(item.directory)
+		if item.overlay && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !lo.Contains(manifestExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(item.directory, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, manifestFile{Name: filepath.Join(item.directory, entry.Name()), Content: string(content), Overlay: item.overlay})
+		}
+	}
+	if len(lo.Filter(files, func(item manifestFile, _ int) bool { return !item.Overlay })) == 0 {
+		return nil, errors.New(fmt.Sprintf("Manifest Directory %s Has No Manifest (%s)", rootPath, strings.Join(manifestExtensions, " / ")))
+	}
+	return files, nil
+}
+
+// substituteVariables 替换清单中的 ${NAME} 变量 (优先使用目标变量, 其次读取 P_ / CI_ 前缀的环境变量), 存在未定义变量时返回错误
+func substituteVariables(content string, variables map[string]string) (string, error) {
+	var missing []string
+	var result = manifestVariablePattern.ReplaceAllStringFunc(content, func(item string) string {
+		if strings.HasPrefix(item, "$$") {
+			return item[1:]
+		}
+		var name = manifestVariablePattern.FindStringSubmatch(item)[1]
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		// 先校验前缀, 其余变量不读取 (避免向服务器查询密钥)
+		var allowed = lo.ContainsBy(manifestEnvironmentPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(name, prefix)
+		})
+		if allowed {
+			if value, ok := environment.Get(name); ok {
+				return value
+			}
+		}
+		missing = append(missing, name)
+		return item
+	})
+	if len(missing) > 0 {
+		return "", errors.New(fmt.Sprintf("Manifest Variables Not Exist: %s (Environment Variables Must Start With %s)",
+			strings.Join(lo.Uniq(missing), ", "), strings.Join(manifestEnvironmentPrefixes, " / ")))
+	}
+	return result, nil
+}
+
+// decodeManifest 解析多文档 YAML / JSON 清单
+func decodeManifest(name, content string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	var decoder = yaml.NewYAMLOrJSONDecoder(bytes.NewReader([]byte(content)), 4096)
+	for {
+		var object map[string]interface{}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Manifest %s Parse Fail: %s", name, err))
+		}
+		if len(object) == 0 {
+			continue
+		}
+		var item = &unstructured.Unstructured{Object: object}
+		if item.GetKind() == "" || item.GetName() == "" {
+			return nil, errors.New(fmt.Sprintf("Manifest %s Object Kind Or Name Is Empty", name))
+		}
+		objects = append(objects, item)
+	}
+}
+
+// mergeObject 以 JSON Merge Patch 语义合并覆盖对象 (null 删除字段, 对象递归合并, 其余替换)
+func mergeObject(base, overlay map[string]interface{}) map[string]interface{} {
+	for key, value := range overlay {
+		if value == nil {
+			delete(base, key)
+			continue
+		}
+		overlayMap, ok := value.(map[string]interface{})
+		baseMap, baseOk := base[key].(map[string]interface{})
+		if ok && baseOk {
+			base[key] = mergeObject(baseMap, overlayMap)
+			continue
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// renderManifests 渲染清单: 替换变量, 解析文档, 合并同类型同名称的环境覆盖对象
+func renderManifests(files []manifestFile, variables map[string]string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, file := range files {
+		content, err := substituteVariables(file.Content, variables)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Manifest %s: %s", file.Name, err))
+		}
+		items, err := decodeManifest(file.Name, content)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			base, ok := lo.Find(objects, func(object *unstructured.Unstructured) bool {
+				return object.GetKind() == item.GetKind() && object.GetName() == item.GetName()
+			})
+			if file.Overlay && ok {
+				base.Object = mergeObject(base.Object, item.Object)
+				continue
+			}
+			objects = append(objects, item)
+		}
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return manifestKindIndex(objects[i].GetKind()) < manifestKindIndex(objects[j].GetKind())
+	})
+	return objects, nil
+}
+
+// manifestKindIndex 清单对象应用顺序
+func manifestKindIndex(kind string) int {
+	var index = lo.IndexOf(manifestKindOrder, kind)
+	return lo.Ternary(index < 0, len(manifestKindOrder), index)
+}
+
+// manifestClient 清单应用客户端 (动态客户端与资源映射)
+type manifestClient struct {
+	*Kubernetes
+	dynamic dynamic.Interface
+	mapper  *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// newManifestClient 创建清单应用客户端
+func (k Kubernetes) newManifestClient() (*manifestClient, error) {
+	if k.config == nil {
+		return nil, errors.New(fmt.Sprintf("%s Manifest Rest Config Empty", k.colony))
+	}
+	dynamicClient, err := dynamic.NewForConfig(k.config)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestClient{
+		Kubernetes: &k,
+		dynamic:    dynamicClient,
+		mapper:     restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(k.Discovery())),
+	}, nil
+}
+
+// mapping 查询对象类型对应的资源映射 (未找到时刷新映射后重试一次, 兼容同批应用的自定义资源)
+func (m *manifestClient) mapping(apiVersion, kind string) (*meta.RESTMapping, error) {
+	var gvk = schema.FromAPIVersionAndKind(apiVersion, kind)
+	mapping, err := m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		m.mapper.Reset()
+		mapping, err = m.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
+
+// resource 查询对象引用对应的资源客户端
+func (m *manifestClient) resource(reference manifestReference) (dynamic.ResourceInterface, error) {
+	mapping, err := m.mapping(reference.ApiVersion, reference.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return m.dynamic.Resource(mapping.Resource).Namespace(reference.Namespace), nil
+	}
+	return m.dynamic.Resource(mapping.Resource), nil
+}
+
+// apply 服务端应用对象 (命名空间资源未指定命名空间时使用目标命名空间)
+func (m *manifestClient) apply(object *unstructured.Unstructured, namespace string) (manifestReference, error) {
+	mapping, err := m.mapping(object.GetAPIVersion(), object.GetKind())
+	if err != nil {
+		return manifestReference{Kind: object.GetKind(), Name: object.GetName()}, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		object.SetNamespace("")
+	} else if object.GetNamespace() == "" {
+		object.SetNamespace(namespace)
+	}
+	var reference = manifestReference{ApiVersion: object.GetAPIVersion(), Kind: object.GetKind(), Namespace: object.GetNamespace(), Name: object.GetName()}
+	resource, err := m.resource(reference)
+	if err != nil {
+		return reference, err
+	}
+	var options = metav1.ApplyOptions{FieldManager: manifestFieldManager, Force: true}
+	if m.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] Apply %s -> %s", m.colony, reference))
+	_, err = resource.Apply(context.Background(), reference.Name, object, options)
+	return reference, err
+}
+
+// prune 删除上次应用但本次已移除的对象
+func (m *manifestClient) prune(reference manifestReference) error {
+	resource, err := m.resource(reference)
+	if err != nil {
+		return err
+	}
+	var propagation = metav1.DeletePropagationBackground
+	var options = metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if m.dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	color.Yellow(fmt.Sprintf("[Kubernetes] Prune %s -> %s", m.colony, reference))
+	err = resource.Delete(context.Background(), reference.Name, options)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// inventory 读取上次应用的对象记录
+func (m *manifestClient) inventory(namespace, serviceName string) ([]manifestReference, error) {
+	configMap, err := m.CoreV1().ConfigMaps(namespace).Get(context.Background(), manifestInventoryPrefix+serviceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var references []manifestReference
+	err = json.Unmarshal([]byte(configMap.Data[manifestInventoryKey]), &references)
+	return references, err
+}
+
+// saveInventory 保存本次应用的对象记录
+func (m *manifestClient) saveInventory(namespace, serviceName string, references []manifestReference) error {
+	value, err := json.Marshal(references)
+	if err != nil {
+		return err
+	}
+	var configMap = &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      manifestInventoryPrefix + serviceName,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedByLabelValue, serviceLabel: serviceName},
+		},
+		Data: map[string]string{manifestInventoryKey: string(value)},
+	}
+	var ctx = context.Background()
+	var client = m.CoreV1().ConfigMaps(namespace)
+	current, err := client.Get(ctx, configMap.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	current.Data = configMap.Data
+	_, err = client.Update(ctx, current, metav1.UpdateOptions{})
+	return err
+}
+
+// ApplyManifests 服务端应用清单对象, prune 为 true 时删除上次应用但本次已移除的对象 (集群级别对象需 pruneCluster 为 true), 返回执行的操作
+func (k Kubernetes) ApplyManifests(namespace, serviceName string, objects []*unstructured.Unstructured, prune, pruneCluster bool) ([][]string, error) {
+	client, err := k.newManifestClient()
+	if err != nil {
+		return nil, err
+	}
+	previous, err := client.inventory(namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	var references []manifestReference
+	for _, object := range objects {
+		reference, err := client.apply(object, namespace)
+		if err != nil {
+			return rows, errors.New(fmt.Sprintf("Apply %s Fail: %s", reference, err))
+		}
+		references = append(references, reference)
+		rows = append(rows, []string{reference.String(), manifestApply})
+	}
+	var removed = lo.Filter(previous, func(reference manifestReference, _ int) bool {
+		return !lo.ContainsBy(references, func(item manifestReference) bool {
+			return item.Kind == reference.Kind && item.Namespace == reference.Namespace && item.Name == reference.Name
+		})
+	})
+	for _, reference := range removed {
+		// 未删除的对象继续保留在记录中, 以便后续启用删除时处理
+		if !prune {
+			references = append(references, reference)
+			continue
+		}
+		// 集群级别对象可能由多个命名空间的记录引用, 默认不删除
+		if reference.Namespace == "" && !pruneCluster {
+			color.Yellow(fmt.Sprintf("[Kubernetes] Skip Prune Cluster Scoped %s -> %s (%s=true To Prune)", k.colony, reference, manifestPruneClusterKey))
+			references = append(references, reference)
+			rows = append(rows, []string{reference.String(), manifestRetain})
+			continue
+		}
+		if err := client.prune(reference); err != nil {
+			return rows, errors.New(fmt.Sprintf("Prune %s Fail: %s", reference, err))
+		}
+		rows = append(rows, []string{reference.String(), manifestPrune})
+	}
+	if k.dryRun {
+		return rows, nil
+	}
+	return rows, client.saveInventory(namespace, serviceName, references)
+}
+
+// ExecuteApplyManifests 渲染清单目录并服务端应用到全部 集群 x 命名空间, prune 为 true 时删除已移除的对象
+func ExecuteApplyManifests(colony, env, namespace, serviceName, rootPath string, prune, dryRun bool) error {
+	prune = prune || environmentBool(manifestPruneKey, false)
+	var pruneCluster = environmentBool(manifestPruneClusterKey, false)
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 清单目录: %s 删除已移除对象: %t 试运行: %t",
+		colony, env, namespace, serviceName, rootPath, prune, dryRun))
+	files, err := readManifestFiles(rootPath, env)
+	if err != nil {
+		return err
+	}
+	// 镜像名称 (按集群解析, 开启时固定镜像摘要)
+	imageName, hasImage := environment.Get("P_IMAGE_NAME")
+	var digests *ImageDigests
+	if hasImage {
+		digests, err = releaseImageDigests()
+		if err != nil {
+			return err
+		}
+	}
+	var lock sync.Mutex
+	var rows [][]string
+	err = eachTarget(colony, namespace, func(colony, namespace string) error {
+		// 目标变量
+		var variables = map[string]string{"COLONY": colony, "COLONY_ENV": env, "NAMESPACE": namespace, "SERVICE_NAME": serviceName}
+		if hasImage {
+			newImageName, err := digests.Resolve(colony, env, imageName)
+			if err != nil {
+				return err
+			}
+			variables["P_IMAGE_NAME"] = newImageName
+		}
+		objects, err := renderManifests(files, variables)
+		if err != nil {
+			return err
+		}
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		items, err := lo.Ternary(dryRun, kubernetesClient.DryRun(), kubernetesClient).ApplyManifests(namespace, serviceName, objects, prune, pruneCluster)
+		lock.Lock()
+		for _, item := range items {
+			rows = append(rows, append([]string{colony, namespace}, item...))
+		}
+		lock.Unlock()
+		return err
+	})
+	common.PrintTable([]string{"集群", "命名空间", "对象", "操作"}, rows)
+	return err
+}
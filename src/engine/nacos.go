@@ -0,0 +1,525 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Nacos 服务类型 (P_SERVICE_TYPE)
+const (
+	nacosServiceAliyun  = "ALIYUN"  // 阿里云 MSE Nacos
+	nacosServiceTencent = "TENCENT" // 腾讯云 TSE Nacos
+	nacosServiceOpen    = "NACOS"   // 开源 Nacos (v1 / v2 Open API)
+)
+
+// Nacos 默认分组
+const nacosDefaultGroup = "DEFAULT_GROUP"
+
+// Nacos 删除策略 环境变量
+const (
+	nacosPruneKey     = "P_NACOS_PRUNE"      // 删除本地不存在的线上配置 (true / false, 默认 false)
+	nacosProtectKey   = "P_NACOS_PROTECT"    // 禁止删除的数据ID (逗号分隔, 支持通配符, 例如: application,shared-*)
+	nacosMaxDeleteKey = "P_NACOS_MAX_DELETE" // 单次同步最大删除数量 (超过时终止同步, 0 为不限制)
+)
+
+// Nacos 单次同步默认最大删除数量
+const nacosDefaultMaxDelete = 10
+
+// Nacos 保留操作 (未开启删除或受保护的配置)
+const nacosRetain = "Retain"
+
+// Nacos 分组映射文件 环境变量 (相对配置目录, 内容: {"目录名称": "分组", ".": "根目录文件分组"})
+const nacosGroupFileKey = "P_NACOS_GROUP_FILE"
+
+// Nacos 默认分组映射文件
+const nacosDefaultGroupFile = ".nacos-group.json"
+
+// Nacos 配置差异上下文行数
+const nacosDiffContext = 3
+
+// ErrNacosPlanChanges 同步计划中存在待执行的变更 (--plan)
+var ErrNacosPlanChanges = errors.New("Nacos Sync Plan Has Pending Changes")
+
+// NacosNamespace Nacos 命名空间
+type NacosNamespace struct {
+	Id          string // 命名空间ID
+	Name        string // 命名空间名称
+	ConfigCount int    // 配置数量
+}
+
+// NacosConfig Nacos 配置
+type NacosConfig struct {
+	Group   string // 分组
+	DataId  string // 数据ID
+	Content string // 配置内容 (列表中可能为空)
+	Type    string // 配置类型 (yaml / properties / json ...)
+}
+
+// NacosProvider Nacos 配置服务 (各云厂商实现该接口后共用同步逻辑)
+type NacosProvider interface {
+	// Namespaces 获取命名空间列表
+	Namespaces() ([]NacosNamespace, error)
+	// ListConfigs 获取命名空间下的配置列表
+	ListConfigs(namespaceId string) ([]NacosConfig, error)
+	// GetConfig 获取配置详情 (包含配置内容)
+	GetConfig(namespaceId, group, dataId string) (*NacosConfig, error)
+	// CreateConfig 创建配置
+	CreateConfig(namespaceId string, config NacosConfig) error
+	// UpdateConfig 修改配置
+	UpdateConfig(namespaceId string, config NacosConfig) error
+	// DeleteConfig 删除配置
+	DeleteConfig(namespaceId, group, dataId string) error
+}
+
+// NacosProviderFactory 通过实例配置 (GL_NACOS_CONFIG_<实例ID> 的 JSON 内容) 创建 Nacos 配置服务
+type NacosProviderFactory func(config map[string]string) (NacosProvider, error)
+
+// Nacos 服务类型注册表
+var nacosProviders = map[string]NacosProviderFactory{
+	nacosServiceAliyun:  newAliyunNacosProvider,
+	nacosServiceTencent: newTencentNacosProvider,
+	nacosServiceOpen:    newOpenNacosProvider,
+}
+
+// RegisterNacosProvider 注册 Nacos 服务类型 (服务类型转为大写)
+func RegisterNacosProvider(serviceType string, factory NacosProviderFactory) {
+	nacosProviders[strings.ToUpper(serviceType)] = factory
+}
+
+// nacosConfigParams 读取实例配置中的必填参数
+func nacosConfigParams(serviceType string, config map[string]string, keys ...string) ([]string, error) {
+	var values []string
+	for _, key := range keys {
+		value, ok := config[key]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%s Config Json Param Error: %s Not Exist", serviceType, key))
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// NewNacosProvider 按服务类型与实例ID (读取 GL_NACOS_CONFIG_<实例ID>) 创建 Nacos 配置服务
+func NewNacosProvider(serviceType, instanceKey string) (NacosProvider, error) {
+	factory, ok := nacosProviders[strings.ToUpper(serviceType)]
+	if !ok {
+		var serviceTypes = lo.Keys(nacosProviders)
+		sort.Strings(serviceTypes)
+		return nil, errors.New(fmt.Sprintf("Nacos Service Type %s Not Support (%s)", serviceType, strings.Join(serviceTypes, " / ")))
+	}
+	var key = configNacosKey + instanceKey
+	instanceJson, ok := environment.Get(key)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Run Sync Nacos Not Find Param: %s", key))
+	}
+	var config = map[string]string{}
+	err := json.Unmarshal([]byte(instanceJson), &config)
+	if err != nil {
+		return nil, err
+	}
+	return factory(config)
+}
+
+// nacosFile 本地配置文件 (分组为第一级目录, 数据ID 为去除扩展名的文件名, 配置类型为扩展名)
+type nacosFile struct {
+	Path string // 相对路径
+	NacosConfig
+}
+
+// key 配置唯一标识 (分组/数据ID)
+func (c NacosConfig) key() string {
+	return c.Group + "/" + c.DataId
+}
+
+// nacosGroupMapping 读取分组映射文件 (文件不存在时按目录名称作为分组), 返回映射与映射文件路径
+func nacosGroupMapping(rootPath string) (map[string]string, string, error) {
+	var fileName = nacosDefaultGroupFile
+	if value, ok := environment.Get(nacosGroupFileKey); ok {
+		fileName = value
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(rootPath, fileName)
+	}
+	var mapping = map[string]string{}
+	content, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return mapping, fileName, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	err = json.Unmarshal(content, &mapping)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Nacos Group Mapping File %s Format Error: %s", fileName, err))
+	}
+	return mapping, fileName, nil
+}
+
+// readNacosFiles 读取配置目录中的全部文件 (忽略 . 开头的文件与目录)
+//
+//	<目录>/application.yaml           -> DEFAULT_GROUP / application
+//	<目录>/SHARED_GROUP/redis.yaml    -> SHARED_GROUP / redis
+//	<目录>/.nacos-group.json          -> {".": "根目录分组", "shared": "SHARED_GROUP"}
+func readNacosFiles(rootPath string) ([]nacosFile, error) {
+	var pathSeparator = string(os.PathSeparator)
+	rootPath = strings.ReplaceAll(rootPath, "\\", pathSeparator)
+	rootPath = strings.ReplaceAll(rootPath, "/", pathSeparator)
+	if _, err := os.Stat(rootPath); err != nil {
+		return nil, errors.New(fmt.Sprintf("Nacos Config Directory %s Not: %s", rootPath, err))
+	}
+	mapping, mappingFile, err := nacosGroupMapping(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []nacosFile
+	var paths = map[string]string{}
+	err = filepath.Walk(rootPath, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath != rootPath && strings.HasPrefix(info.Name(), ".") {
+			return lo.Ternary(info.IsDir(), filepath.SkipDir, nil)
+		}
+		if info.IsDir() || filePath == mappingFile {
+			return nil
+		}
+		relativePath, err := filepath.Rel(rootPath, filePath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		var group, name, nested = strings.Cut(relativePath, "/")
+		if !nested {
+			group, name = lo.Ternary(mapping["."] == "", nacosDefaultGroup, mapping["."]), relativePath
+		} else if strings.Contains(name, "/") {
+			return errors.New(fmt.Sprintf("Nacos Config File %s Nested Too Deep (<分组>/<数据ID>.<类型>)", relativePath))
+		} else if mapping[group] != "" {
+			group = mapping[group]
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		var extension = path.Ext(name)
+		var file = nacosFile{Path: relativePath, NacosConfig: NacosConfig{
+			Group:   group,
+			DataId:  strings.TrimSuffix(name, extension),
+			Content: string(content),
+			Type:    strings.TrimPrefix(extension, "."),
+		}}
+		if duplicate, ok := paths[file.key()]; ok {
+			return errors.New(fmt.Sprintf("Nacos Config %s Duplicate: %s, %s", file.key(), duplicate, relativePath))
+		}
+		paths[file.key()] = relativePath
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// NacosSyncOptions Nacos 同步参数
+type NacosSyncOptions struct {
+	Plan            bool     // 仅输出同步计划与内容差异
+	Prune           bool     // 删除本地不存在的线上配置
+	Protect         []string // 禁止删除的数据ID (支持通配符, 匹配 数据ID 或 分组/数据ID)
+	MaxDelete       int      // 单次同步最大删除数量 (超过时终止同步, 0 为不限制)
+	BackupDirectory string   // 删除前备份目录 (为空时拒绝删除)
+}
+
+// nacosChange Nacos 配置变更
+type nacosChange struct {
+	Operation string       // 操作 (Create / Update / Delete / Retain)
+	Reason    string       // 说明 (保留原因)
+	Path      string       // 本地文件相对路径 (删除时为空)
+	Local     NacosConfig  // 本地配置 (删除时为空)
+	Remote    *NacosConfig // 线上配置 (新增时为空)
+}
+
+// planNacosSync 对比本地配置与线上配置, 返回新增、修改与删除的配置
+func planNacosSync(provider NacosProvider, namespaceId string, files []nacosFile) ([]nacosChange, error) {
+	configList, err := provider.ListConfigs(namespaceId)
+	if err != nil {
+		return nil, err
+	}
+	var changes []nacosChange
+	// 是否存在新增
+	for _, file := range files {
+		if !lo.ContainsBy(configList, func(item NacosConfig) bool { return item.key() == file.key() }) {
+			changes = append(changes, nacosChange{Operation: configCreate, Path: file.Path, Local: file.NacosConfig})
+		}
+	}
+	for _, item := range configList {
+		file, ok := lo.Find(files, func(file nacosFile) bool { return file.key() == item.key() })
+		if !ok {
+			// 是否存在删除
+			var remote = item
+			changes = append(changes, nacosChange{Operation: configDelete, Remote: &remote})
+			continue
+		}
+		// 是否存在修改
+		config, err := provider.GetConfig(namespaceId, item.Group, item.DataId)
+		if err != nil {
+			return nil, err
+		}
+		if config.Content == file.Content && config.Type == file.Type {
+			continue
+		}
+		changes = append(changes, nacosChange{Operation: configUpdate, Path: file.Path, Local: file.NacosConfig, Remote: config})
+	}
+	// 按 分组 / 数据ID 排序输出
+	sort.SliceStable(changes, func(i, j int) bool {
+		var a, b = changes[i].config(), changes[j].config()
+		return a.Group < b.Group || (a.Group == b.Group && a.DataId < b.DataId)
+	})
+	return changes, nil
+}
+
+// nacosProtected 配置是否受保护 (返回匹配的规则)
+func nacosProtected(patterns []string, config NacosConfig) (string, bool) {
+	for _, pattern := range patterns {
+		for _, name := range []string{config.DataId, config.Group + "/" + config.DataId} {
+			if ok, _ := path.Match(pattern, name); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// nacosDeletePolicy 按删除策略处理待删除的配置 (未开启删除或受保护的配置保留), 读取仍需删除的配置内容用于输出差异与备份
+func nacosDeletePolicy(provider NacosProvider, namespaceId string, changes []nacosChange, options NacosSyncOptions) error {
+	for i := range changes {
+		var change = &changes[i]
+		if change.Operation != configDelete {
+			continue
+		}
+		if !options.Prune {
+			change.Operation, change.Reason = nacosRetain, nacosPruneKey+"=false"
+			continue
+		}
+		if pattern, ok := nacosProtected(options.Protect, *change.Remote); ok {
+			change.Operation, change.Reason = nacosRetain, "Protect: "+pattern
+			continue
+		}
+		config, err := provider.GetConfig(namespaceId, change.Remote.Group, change.Remote.DataId)
+		if err != nil {
+			return err
+		}
+		change.Remote = config
+	}
+	return nil
+}
+
+// backupNacosConfigs 备份待删除的配置到 <备份目录>/<命名空间>/<时间>/<分组>/<数据ID>.<类型>, 返回本次备份目录
+func backupNacosConfigs(namespaceId, backupDirectory string, changes []nacosChange) (string, error) {
+	var directory = filepath.Join(backupDirectory, lo.Ternary(namespaceId == "", "public", namespaceId), time.Now().Format("20060102150405"))
+	for _, change := range changes {
+		if change.Operation != configDelete {
+			continue
+		}
+		var fileName = filepath.Join(directory, change.Remote.Group, filepath.FromSlash(change.Remote.DataId)) +
+			lo.Ternary(change.Remote.Type == "", "", "."+change.Remote.Type)
+		err := os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err != nil {
+			return "", err
+		}
+		err = os.WriteFile(fileName, []byte(change.Remote.Content), 0644)
+		if err != nil {
+			return "", err
+		}
+	}
+	return directory, nil
+}
+
+// applyNacosChanges 执行配置变更 (删除前先备份待删除的配置)
+func applyNacosChanges(provider NacosProvider, namespaceId string, changes []nacosChange, options NacosSyncOptions) error {
+	if lo.ContainsBy(changes, func(change nacosChange) bool { return change.Operation == configDelete }) {
+		if options.BackupDirectory == "" {
+			return errors.New("Nacos Backup Directory Not Set, Refuse To Delete")
+		}
+		directory, err := backupNacosConfigs(namespaceId, options.BackupDirectory, changes)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Nacos Backup Fail, Refuse To Delete: %s", err))
+		}
+		color.Green(fmt.Sprintf("[Nacos] Backup Deleted Configs To: %s", directory))
+		// 备份目录位于流水线工作目录时, 任务结束后会被清理, 需要声明为制品保留
+		if workDirectory, ok := environment.Get("CI_PROJECT_DIR"); ok {
+			if relativePath, err := filepath.Rel(workDirectory, directory); err == nil && !strings.HasPrefix(relativePath, "..") {
+				color.Yellow(fmt.Sprintf("[Nacos] Backup Is Inside The Job Workspace, Keep It As Job Artifact: artifacts: paths: [%s]",
+					filepath.ToSlash(relativePath)))
+			}
+		}
+	}
+	for _, change := range changes {
+		var err error
+		switch change.Operation {
+		case configCreate:
+			err = provider.CreateConfig(namespaceId, change.Local)
+		case configUpdate:
+			err = provider.UpdateConfig(namespaceId, change.Local)
+		case configDelete:
+			err = provider.DeleteConfig(namespaceId, change.Remote.Group, change.Remote.DataId)
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("Nacos %s %s Fail: %s", change.Operation, change.config().DataId, err))
+		}
+	}
+	return nil
+}
+
+// config 变更的配置 (本地文件不存在时为线上配置, 否则为本地配置)
+func (c nacosChange) config() NacosConfig {
+	if c.Path == "" {
+		return *c.Remote
+	}
+	return c.Local
+}
+
+// checkNacosNamespace 校验命名空间是否存在 (按命名空间ID 或名称匹配, 无法读取命名空间列表或列表为空时无法校验, 仅提示)
+func checkNacosNamespace(provider NacosProvider, namespaceId string) error {
+	namespaces, err := provider.Namespaces()
+	if err != nil {
+		color.Yellow(fmt.Sprintf("[Nacos] Read Namespaces Fail, Skip Check: %s", err))
+		return nil
+	}
+	if len(namespaces) == 0 {
+		color.Yellow("[Nacos] Namespaces Empty, Skip Check")
+		return nil
+	}
+	if !lo.ContainsBy(namespaces, func(item NacosNamespace) bool { return item.Id == namespaceId || item.Name == namespaceId }) {
+		return errors.New(fmt.Sprintf("Nacos Namespace %s Not Exist", namespaceId))
+	}
+	return nil
+}
+
+// printNacosChanges 输出配置变更
+func printNacosChanges(changes []nacosChange) {
+	var rows [][]string
+	for _, change := range changes {
+		var config = change.config()
+		rows = append(rows, []string{change.Operation, config.Group, config.DataId, config.Type, lo.Ternary(change.Path == "", "-", change.Path),
+			lo.Ternary(change.Reason == "", "-", change.Reason)})
+	}
+	common.PrintTable([]string{"操作", "分组", "数据ID", "类型", "文件", "说明"}, rows)
+	var count = lo.CountValuesBy(changes, func(change nacosChange) string { return change.Operation })
+	color.Green(fmt.Sprintf("[Nacos] Create: %d条 Update: %d条 Delete: %d条 Retain: %d条", count[configCreate], count[configUpdate],
+		count[configDelete], count[nacosRetain]))
+}
+
+// printNacosDiff 输出每个变更配置的线上内容与本地文件的统一格式差异
+func printNacosDiff(changes []nacosChange) {
+	for _, change := range changes {
+		if change.Operation == nacosRetain {
+			continue
+		}
+		var config = change.config()
+		var fromName, toName = "/dev/null", "/dev/null"
+		var from, to string
+		if change.Remote != nil {
+			fromName, from = "nacos/"+change.Remote.Group+"/"+change.Remote.DataId, change.Remote.Content
+		}
+		if change.Operation != configDelete {
+			toName, to = "local/"+change.Path, change.Local.Content
+		}
+		color.Cyan(fmt.Sprintf("[Nacos] %s %s/%s", change.Operation, config.Group, config.DataId))
+		if change.Operation == configUpdate && change.Remote.Type != change.Local.Type {
+			color.Yellow(fmt.Sprintf("type: %s -> %s", lo.Ternary(change.Remote.Type == "", "-", change.Remote.Type), change.Local.Type))
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(common.UnifiedDiff(fromName, toName, from, to, nacosDiffContext), "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				color.Cyan(line)
+			case strings.HasPrefix(line, "-"):
+				color.Red(line)
+			case strings.HasPrefix(line, "+"):
+				color.Green(line)
+			default:
+				fmt.Println(line)
+			}
+		}
+	}
+}
+
+// SyncNacos 同步配置目录到 Nacos 命名空间 (新增本地新增的配置, 修改内容或类型变化的配置, 按删除策略删除本地不存在的配置),
+// options.Plan 为 true 时仅输出变更与内容差异, 存在变更时返回 ErrNacosPlanChanges
+func SyncNacos(provider NacosProvider, namespaceId, rootPath string, options NacosSyncOptions) error {
+	color.Blue(fmt.Sprintf("[Nacos] %s Nacos Config By NamespaceId: %s Directory: %s Prune: %t MaxDelete: %d",
+		lo.Ternary(options.Plan, "Plan", "Sync"), namespaceId, rootPath, options.Prune, options.MaxDelete))
+	files, err := readNacosFiles(rootPath)
+	if err != nil {
+		return err
+	}
+	err = checkNacosNamespace(provider, namespaceId)
+	if err != nil {
+		return err
+	}
+	changes, err := planNacosSync(provider, namespaceId, files)
+	if err != nil {
+		return err
+	}
+	err = nacosDeletePolicy(provider, namespaceId, changes, options)
+	if err != nil {
+		return err
+	}
+	printNacosChanges(changes)
+	var deleteCount = lo.CountBy(changes, func(change nacosChange) bool { return change.Operation == configDelete })
+	var exceeded = options.MaxDelete > 0 && deleteCount > options.MaxDelete
+	var exceededMessage = fmt.Sprintf("Nacos Delete %d Configs Exceeds %s=%d, Abort Sync", deleteCount, nacosMaxDeleteKey, options.MaxDelete)
+	if !options.Plan {
+		if exceeded {
+			return errors.New(exceededMessage)
+		}
+		return applyNacosChanges(provider, namespaceId, changes, options)
+	}
+	// 同步计划先输出内容差异, 再提示超过删除上限 (仍返回 ErrNacosPlanChanges)
+	printNacosDiff(changes)
+	if exceeded {
+		color.Red(fmt.Sprintf("[Nacos] %s", exceededMessage))
+		return fmt.Errorf("%w: %s", ErrNacosPlanChanges, exceededMessage)
+	}
+	if lo.ContainsBy(changes, func(change nacosChange) bool { return change.Operation != nacosRetain }) {
+		return ErrNacosPlanChanges
+	}
+	color.Green("[Nacos] No Changes")
+	return nil
+}
+
+// nacosSyncOptions 读取同步参数 (删除策略读取 P_NACOS_PRUNE / P_NACOS_PROTECT / P_NACOS_MAX_DELETE)
+func nacosSyncOptions(plan, prune bool, backupDirectory string) NacosSyncOptions {
+	var protect []string
+	if value, ok := environment.Get(nacosProtectKey); ok {
+		protect = lo.Compact(lo.Map(strings.Split(value, ","), func(item string, _ int) string { return strings.TrimSpace(item) }))
+	}
+	return NacosSyncOptions{
+		Plan:            plan,
+		Prune:           prune || environmentBool(nacosPruneKey, false),
+		Protect:         protect,
+		MaxDelete:       environmentInt(nacosMaxDeleteKey, nacosDefaultMaxDelete),
+		BackupDirectory: backupDirectory,
+	}
+}
+
+// ExecuteNacosSync 按服务类型 (P_SERVICE_TYPE) 同步配置目录到 Nacos 命名空间, plan 为 true 时仅输出同步计划,
+// prune 为 true 时删除本地不存在的配置 (删除前备份到 backupDirectory)
+func ExecuteNacosSync(serviceType, instanceKey, namespaceId, rootPath, backupDirectory string, plan, prune bool) error {
+	provider, err := NewNacosProvider(serviceType, instanceKey)
+	if err != nil {
+		return err
+	}
+	return SyncNacos(provider, namespaceId, rootPath, nacosSyncOptions(plan, prune, backupDirectory))
+}
@@ -0,0 +1,464 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 开源 Nacos Open API 版本
+const (
+	openNacosV1 = "v1" // Nacos 1.x: /v1/cs/configs
+	openNacosV2 = "v2" // Nacos 2.x: /v2/cs/config
+)
+
+// 开源 Nacos 默认上下文路径
+const openNacosContextPath = "/nacos"
+
+// 开源 Nacos 请求超时时间
+const openNacosRequestTimeout = 30 * time.Second
+
+// 开源 Nacos 配置列表分页大小 (v1)
+const openNacosPageSize = 100
+
+// OpenNacosOptions 开源 Nacos 连接参数
+type OpenNacosOptions struct {
+	Host        string // 服务地址 (host:port 或包含协议的完整地址)
+	Scheme      string // 协议 (http / https, 服务地址不包含协议时使用, 默认 http)
+	ContextPath string // 上下文路径 (默认 /nacos, 部署在根路径时为 /)
+	Version     string // Open API 版本 (v1 / v2, 默认 v2)
+	Username    string // 用户名 (为空时不登录)
+	Password    string // 密码
+	AccessKey   string // AK/SK 签名 AccessKey (为空时不签名)
+	SecretKey   string // AK/SK 签名 SecretKey
+	Insecure    bool   // 跳过 HTTPS 证书校验
+}
+
+// OpenNacos 开源 Nacos 客户端 (官方 Open API)
+type OpenNacos struct {
+	options     OpenNacosOptions
+	baseUrl     string            // 服务地址 + 上下文路径
+	client      *http.Client      // 请求客户端
+	lock        sync.Mutex        // 令牌锁
+	accessToken string            // 访问令牌
+	expireTime  time.Time         // 令牌刷新时间
+	types       map[string]string // 配置类型 (v2 获取配置接口不返回配置类型, 从配置列表中读取)
+}
+
+// openNacosConfigItem 开源 Nacos 配置列表项
+type openNacosConfigItem struct {
+	DataId  string `json:"dataId"`  // 数据ID
+	Group   string `json:"group"`   // 分组
+	Content string `json:"content"` // 配置内容
+	Type    string `json:"type"`    // 配置类型
+}
+
+// openNacosNamespace 开源 Nacos 命名空间
+type openNacosNamespace struct {
+	Namespace         string `json:"namespace"`         // 命名空间ID
+	NamespaceShowName string `json:"namespaceShowName"` // 命名空间名称
+	ConfigCount       int    `json:"configCount"`       // 配置数量
+}
+
+// NewOpenNacos 创建开源 Nacos 客户端 (配置用户名时立即登录)
+func NewOpenNacos(options OpenNacosOptions) (*OpenNacos, error) {
+	if options.Host == "" {
+		return nil, errors.New("Nacos Host Not Exist")
+	}
+	options.Version = strings.ToLower(lo.Ternary(options.Version == "", openNacosV2, options.Version))
+	if options.Version != openNacosV1 && options.Version != openNacosV2 {
+		return nil, errors.New(fmt.Sprintf("Nacos Open API Version %s Not Support (%s / %s)", options.Version, openNacosV1, openNacosV2))
+	}
+	var address = options.Host
+	if !strings.Contains(address, "://") {
+		address = lo.Ternary(options.Scheme == "", "http", strings.ToLower(options.Scheme)) + "://" + address
+	}
+	hostUrl, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	var contextPath = options.ContextPath
+	if contextPath == "" {
+		contextPath = lo.Ternary(strings.Trim(hostUrl.Path, "/") == "", openNacosContextPath, hostUrl.Path)
+	}
+	contextPath = strings.Trim(contextPath, "/")
+	var nacos = &OpenNacos{
+		options: options,
+		baseUrl: hostUrl.Scheme + "://" + hostUrl.Host + lo.Ternary(contextPath == "", "", "/"+contextPath),
+		client: &http.Client{
+			Timeout:   openNacosRequestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{InsecureSkipVerify: options.Insecure}},
+		},
+		types: map[string]string{},
+	}
+	if options.Username != "" {
+		_, err = nacos.token(true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nacos, nil
+}
+
+// URL 获取开源 Nacos 地址 (包含上下文路径).
+func (nacos *OpenNacos) URL() string {
+	return nacos.baseUrl
+}
+
+// login 登录获取访问令牌 (令牌有效期过去 90% 后刷新)
+func (nacos *OpenNacos) login() error {
+	response, err := nacos.client.PostForm(nacos.baseUrl+"/v1/auth/login", url.Values{
+		"username": {nacos.options.Username},
+		"password": {nacos.options.Password},
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Nacos Login Status %d: %s", response.StatusCode, strings.TrimSpace(string(body))))
+	}
+	var result struct {
+		AccessToken string `json:"accessToken"`
+		TokenTtl    int64  `json:"tokenTtl"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+	if result.AccessToken == "" {
+		return errors.New("Nacos Login Response Not AccessToken")
+	}
+	var ttl = time.Duration(lo.Ternary(result.TokenTtl <= 0, int64(18000), result.TokenTtl)) * time.Second
+	nacos.accessToken = result.AccessToken
+	nacos.expireTime = time.Now().Add(ttl * 9 / 10)
+	return nil
+}
+
+// token 获取访问令牌 (未配置用户名时为空, 令牌过期或 refresh 为 true 时重新登录)
+func (nacos *OpenNacos) token(refresh bool) (string, error) {
+	if nacos.options.Username == "" {
+		return "", nil
+	}
+	nacos.lock.Lock()
+	defer nacos.lock.Unlock()
+	if refresh || nacos.accessToken == "" || time.Now().After(nacos.expireTime) {
+		err := nacos.login()
+		if err != nil {
+			return "", err
+		}
+	}
+	return nacos.accessToken, nil
+}
+
+// sign AK/SK 签名 (Spas-Signature = Base64(HmacSHA1(SecretKey, [命名空间+分组+]时间戳)))
+func (nacos *OpenNacos) sign(header http.Header, params url.Values) {
+	var tenant = lo.Ternary(params.Get("namespaceId") == "", params.Get("tenant"), params.Get("namespaceId"))
+	var resource = strings.Join(lo.Compact([]string{tenant, params.Get("group")}), "+")
+	var timestamp = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	var signData = lo.Ternary(resource == "", timestamp, resource+"+"+timestamp)
+	var mac = hmac.New(sha1.New, []byte(nacos.options.SecretKey))
+	mac.Write([]byte(signData))
+	header.Set("Spas-AccessKey", nacos.options.AccessKey)
+	header.Set("Timestamp", timestamp)
+	header.Set("Spas-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// request 开源 Nacos 请求 (POST 参数使用表单, 其他使用查询参数; 令牌失效时重新登录后重试一次)
+func (nacos *OpenNacos) request(method, urlPath string, params url.Values) ([]byte, http.Header, error) {
+	var refresh bool
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err := nacos.token(refresh)
+		if err != nil {
+			return nil, nil, err
+		}
+		var query = url.Values{}
+		if token != "" {
+			query.Set("accessToken", token)
+		}
+		var body io.Reader
+		if method == http.MethodPost {
+			body = strings.NewReader(params.Encode())
+		} else {
+			for key, values := range params {
+				query[key] = values
+			}
+		}
+		request, err := http.NewRequest(method, nacos.baseUrl+urlPath+"?"+query.Encode(), body)
+		if err != nil {
+			return nil, nil, err
+		}
+		if method == http.MethodPost {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if nacos.options.AccessKey != "" {
+			nacos.sign(request.Header, params)
+		}
+		response, err := nacos.client.Do(request)
+		if err != nil {
+			return nil, nil, err
+		}
+		responseBody, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if (response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden) && token != "" && attempt == 0 {
+			// 令牌过期或失效
+			refresh = true
+			continue
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, nil, errors.New(fmt.Sprintf("Nacos %s %s Status %d: %s", method, urlPath, response.StatusCode, strings.TrimSpace(string(responseBody))))
+		}
+		return responseBody, response.Header, nil
+	}
+	return nil, nil, errors.New(fmt.Sprintf("Nacos %s %s Unauthorized", method, urlPath))
+}
+
+// requestV2 开源 Nacos v2 请求 (响应格式: {"code": 0, "message": "success", "data": ...})
+func (nacos *OpenNacos) requestV2(method, urlPath string, params url.Values, v any) error {
+	body, _, err := nacos.request(method, urlPath, params)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return errors.New(fmt.Sprintf("Nacos %s %s Code %d: %s", method, urlPath, result.Code, result.Message))
+	}
+	return json.Unmarshal(result.Data, v)
+}
+
+// requestV1 开源 Nacos v1 修改请求 (响应内容为 true)
+func (nacos *OpenNacos) requestV1(method, urlPath string, params url.Values) error {
+	body, _, err := nacos.request(method, urlPath, params)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != "true" {
+		return errors.New(fmt.Sprintf("Nacos %s %s Response Fail: %s", method, urlPath, string(body)))
+	}
+	return nil
+}
+
+// typeKey 配置类型缓存键
+func (nacos *OpenNacos) typeKey(namespaceId, group, dataId string) string {
+	return namespaceId + "/" + group + "/" + dataId
+}
+
+// Namespaces 获取命名空间列表.
+func (nacos *OpenNacos) Namespaces() ([]NacosNamespace, error) {
+	var namespaces []openNacosNamespace
+	if nacos.options.Version == openNacosV1 {
+		body, _, err := nacos.request(http.MethodGet, "/v1/console/namespaces", url.Values{})
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Data []openNacosNamespace `json:"data"`
+		}
+		err = json.Unmarshal(body, &result)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = result.Data
+	} else {
+		err := nacos.requestV2(http.MethodGet, "/v2/console/namespace/list", url.Values{}, &namespaces)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lo.Map(namespaces, func(item openNacosNamespace, _ int) NacosNamespace {
+		return NacosNamespace{Id: item.Namespace, Name: item.NamespaceShowName, ConfigCount: item.ConfigCount}
+	}), nil
+}
+
+// ListConfigs 获取配置列表 (v1 分页读取 /v1/cs/configs, v2 读取 /v2/cs/history/configs).
+func (nacos *OpenNacos) ListConfigs(namespaceId string) ([]NacosConfig, error) {
+	var items []openNacosConfigItem
+	if nacos.options.Version == openNacosV1 {
+		for pageNo := 1; ; pageNo++ {
+			body, _, err := nacos.request(http.MethodGet, "/v1/cs/configs", url.Values{
+				"search":   {"accurate"},
+				"dataId":   {""},
+				"group":    {""},
+				"tenant":   {namespaceId},
+				"pageNo":   {strconv.Itoa(pageNo)},
+				"pageSize": {strconv.Itoa(openNacosPageSize)},
+			})
+			if err != nil {
+				return nil, err
+			}
+			var result struct {
+				PagesAvailable int                   `json:"pagesAvailable"`
+				PageItems      []openNacosConfigItem `json:"pageItems"`
+			}
+			err = json.Unmarshal(body, &result)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, result.PageItems...)
+			if pageNo >= result.PagesAvailable || len(result.PageItems) == 0 {
+				break
+			}
+		}
+	} else {
+		err := nacos.requestV2(http.MethodGet, "/v2/cs/history/configs", url.Values{"namespaceId": {namespaceId}}, &items)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lo.Map(items, func(item openNacosConfigItem, _ int) NacosConfig {
+		nacos.types[nacos.typeKey(namespaceId, item.Group, item.DataId)] = item.Type
+		return NacosConfig{Group: item.Group, DataId: item.DataId, Content: item.Content, Type: item.Type}
+	}), nil
+}
+
+// GetConfig 获取配置详情.
+func (nacos *OpenNacos) GetConfig(namespaceId string, group string, dataId string) (*NacosConfig, error) {
+	var config = NacosConfig{Group: group, DataId: dataId, Type: nacos.types[nacos.typeKey(namespaceId, group, dataId)]}
+	if nacos.options.Version == openNacosV1 {
+		body, header, err := nacos.request(http.MethodGet, "/v1/cs/configs", url.Values{
+			"tenant": {namespaceId},
+			"group":  {group},
+			"dataId": {dataId},
+		})
+		if err != nil {
+			return nil, err
+		}
+		config.Content = string(body)
+		if configType := header.Get("Config-Type"); configType != "" {
+			config.Type = configType
+		}
+		return &config, nil
+	}
+	err := nacos.requestV2(http.MethodGet, "/v2/cs/config", url.Values{
+		"namespaceId": {namespaceId},
+		"group":       {group},
+		"dataId":      {dataId},
+	}, &config.Content)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// publish 发布配置 (不存在时创建, 存在时修改).
+func (nacos *OpenNacos) publish(namespaceId string, config NacosConfig) error {
+	var err error
+	if nacos.options.Version == openNacosV1 {
+		err = nacos.requestV1(http.MethodPost, "/v1/cs/configs", url.Values{
+			"tenant":  {namespaceId},
+			"group":   {config.Group},
+			"dataId":  {config.DataId},
+			"content": {config.Content},
+			"type":    {config.Type},
+		})
+	} else {
+		var success bool
+		err = nacos.requestV2(http.MethodPost, "/v2/cs/config", url.Values{
+			"namespaceId": {namespaceId},
+			"group":       {config.Group},
+			"dataId":      {config.DataId},
+			"content":     {config.Content},
+			"type":        {config.Type},
+		}, &success)
+		if err == nil && !success {
+			err = errors.New("Response Fail")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	nacos.types[nacos.typeKey(namespaceId, config.Group, config.DataId)] = config.Type
+	return nil
+}
+
+// CreateConfig 创建配置.
+func (nacos *OpenNacos) CreateConfig(namespaceId string, config NacosConfig) error {
+	return nacos.publish(namespaceId, config)
+}
+
+// UpdateConfig 修改配置.
+func (nacos *OpenNacos) UpdateConfig(namespaceId string, config NacosConfig) error {
+	return nacos.publish(namespaceId, config)
+}
+
+// DeleteConfig 删除配置.
+func (nacos *OpenNacos) DeleteConfig(namespaceId string, group string, dataId string) error {
+	if nacos.options.Version == openNacosV1 {
+		return nacos.requestV1(http.MethodDelete, "/v1/cs/configs", url.Values{
+			"tenant": {namespaceId},
+			"group":  {group},
+			"dataId": {dataId},
+		})
+	}
+	var success bool
+	err := nacos.requestV2(http.MethodDelete, "/v2/cs/config", url.Values{
+		"namespaceId": {namespaceId},
+		"group":       {group},
+		"dataId":      {dataId},
+	}, &success)
+	if err != nil {
+		return err
+	}
+	if !success {
+		return errors.New("Response Fail")
+	}
+	return nil
+}
+
+// newOpenNacosProvider 通过实例配置创建开源 Nacos 客户端.
+//
+//	{"host": "nacos.example.com:8848", "scheme": "https", "contextPath": "/nacos", "version": "v2",
+//	 "username": "nacos", "password": "...", "accessKey": "...", "secretKey": "...", "insecure": "false"}
+func newOpenNacosProvider(config map[string]string) (NacosProvider, error) {
+	values, err := nacosConfigParams(nacosServiceOpen, config, "host")
+	if err != nil {
+		return nil, err
+	}
+	var insecure bool
+	if value, ok := config["insecure"]; ok {
+		insecure, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s Config Json Param Error: insecure %s", nacosServiceOpen, err))
+		}
+	}
+	return NewOpenNacos(OpenNacosOptions{
+		Host:        values[0],
+		Scheme:      config["scheme"],
+		ContextPath: config["contextPath"],
+		Version:     config["version"],
+		Username:    config["username"],
+		Password:    config["password"],
+		AccessKey:   config["accessKey"],
+		SecretKey:   config["secretKey"],
+		Insecure:    insecure,
+	})
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"strconv"
+)
+
+// 发布失败自动回滚 环境变量 (true / false)
+const releaseAutoRollbackKey = "P_RELEASE_AUTO_ROLLBACK"
+
+// 副本集 Pod 模板哈希标签
+const podTemplateHashLabel = "pod-template-hash"
+
+// autoRollback 是否开启发布失败自动回滚
+func autoRollback() bool {
+	return environmentBool(releaseAutoRollbackKey, false)
+}
+
+// RollbackWorkload 回滚工作负载到指定版本 (revision 为 0 时回滚到上一个版本)
+//   - Deployment: 使用历史副本集的 Pod 模板
+//   - StatefulSet / DaemonSet: 使用历史 ControllerRevision
+func (k Kubernetes) RollbackWorkload(workload *Workload, revision int64) error {
+	switch workload.Kind {
+	case KindDeployment:
+		return k.rollbackDeployment(workload, revision)
+	case KindStatefulSet, KindDaemonSet:
+		return k.rollbackControllerRevision(workload, revision)
+	}
+	return errors.New(fmt.Sprintf("%s -> %s Rollback Not Support", k.colony, workload))
+}
+
+// rollbackDeployment 回滚无状态服务到指定副本集版本
+func (k Kubernetes) rollbackDeployment(workload *Workload, revision int64) error {
+	currentRevision, _ := strconv.ParseInt(workload.Object.GetAnnotations()[deploymentRevisionAnnotation], 10, 64)
+	selector, err := labelSelector(workload.Selector)
+	if err != nil {
+		return err
+	}
+	list, err := k.AppsV1().ReplicaSets(workload.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 查找目标版本: 指定版本 或 小于当前版本的最大版本
+	var target *v1.PodTemplateSpec
+	var targetRevision int64
+	for i := range list.Items {
+		var item = list.Items[i]
+		if !metav1.IsControlledBy(&item, workload.Object) {
+			continue
+		}
+		itemRevision, err := strconv.ParseInt(item.Annotations[deploymentRevisionAnnotation], 10, 64)
+		if err != nil || itemRevision == currentRevision {
+			continue
+		}
+		if (revision > 0 && itemRevision == revision) || (revision == 0 && itemRevision < currentRevision && itemRevision > targetRevision) {
+			target = item.Spec.Template.DeepCopy()
+			targetRevision = itemRevision
+		}
+	}
+	if target == nil {
+		return errors.New(fmt.Sprintf("%s -> %s Rollback Revision Not (Current: %d)", k.colony, workload, currentRevision))
+	}
+	delete(target.Labels, podTemplateHashLabel)
+	color.Blue(fmt.Sprintf("[Kubernetes] Rollback %s Revision: %d -> %d", workload, currentRevision, targetRevision))
+	_, err = k.RestoreWorkloadTemplate(workload, target)
+	return err
+}
+
+// rollbackControllerRevision 回滚有状态服务、守护进程集到指定 ControllerRevision 版本
+func (k Kubernetes) rollbackControllerRevision(workload *Workload, revision int64) error {
+	selector, err := labelSelector(workload.Selector)
+	if err != nil {
+		return err
+	}
+	list, err := k.AppsV1().ControllerRevisions(workload.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return err
+	}
+	var revisions = lo.Filter(list.Items, func(item v12.ControllerRevision, _ int) bool {
+		return metav1.IsControlledBy(&item, workload.Object)
+	})
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	if len(revisions) == 0 {
+		return errors.New(fmt.Sprintf("%s -> %s Rollback Revision Not", k.colony, workload))
+	}
+
+	// 查找目标版本: 指定版本 或 当前版本的上一个版本
+	var currentRevision = revisions[len(revisions)-1].Revision
+	var target *v12.ControllerRevision
+	for i := range revisions {
+		if (revision > 0 && revisions[i].Revision == revision) || (revision == 0 && revisions[i].Revision < currentRevision) {
+			target = &revisions[i]
+		}
+	}
+	if target == nil || target.Revision == currentRevision {
+		return errors.New(fmt.Sprintf("%s -> %s Rollback Revision Not (Current: %d)", k.colony, workload, currentRevision))
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] Rollback %s Revision: %d -> %d", workload, currentRevision, target.Revision))
+	_, err = k.PatchWorkload(workload, types.StrategicMergePatchType, target.Data.Raw)
+	return err
+}
+
+// rollbackRelease 发布失败后恢复发布前的 Pod 模板, 返回包含回滚结果的错误
+func (k Kubernetes) rollbackRelease(workload *Workload, previousTemplate *v1.PodTemplateSpec, releaseErr error) error {
+	current, err := k.GetWorkload(workload.Kind, workload.Namespace, workload.Name)
+	if err == nil && equality.Semantic.DeepEqual(*current.Template, *previousTemplate) {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Pod Template Unchanged, Skip Rollback", workload))
+		return releaseErr
+	}
+	color.Yellow(fmt.Sprintf("[Kubernetes] %s -> %s Release Fail, Auto Rollback ...", k.colony, workload))
+	_, err = k.RestoreWorkloadTemplate(workload, previousTemplate)
+	if err == nil && workload.onDelete() {
+		err = k.recreateWorkloadPods(workload, releaseTimeout())
+	}
+	if err == nil {
+		err = k.WaitWorkloadRollout(workload, releaseTimeout())
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s; rollback fail: %s", releaseErr, err))
+	}
+	return errors.New(fmt.Sprintf("%s; rolled back to %s", releaseErr, templateImages(previousTemplate)))
+}
+
+// templateImages Pod 模板中的容器镜像描述
+func templateImages(template *v1.PodTemplateSpec) string {
+	var images string
+	for i, container := range template.Spec.Containers {
+		if i > 0 {
+			images += ", "
+		}
+		images += container.Name + "=" + container.Image
+	}
+	return images
+}
@@ -0,0 +1,310 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 发布超时时间 环境变量 (秒 或 Duration 格式, 例如: 300 / 5m)
+const releaseTimeoutKey = "P_RELEASE_TIMEOUT"
+
+// 默认发布超时时间
+const defaultReleaseTimeout = 5 * time.Minute
+
+// 发布状态轮询间隔
+const rolloutPollInterval = 3 * time.Second
+
+// 无状态服务版本号注解
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// 发布失败时输出的最近事件数量
+const rolloutEventLimit = 20
+
+// 容器等待原因 出现即判定发布失败
+var rolloutFailureReasons = []string{
+	"CrashLoopBackOff",
+	"ImagePullBackOff",
+	"ErrImagePull",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+	"RunContainerError",
+}
+
+// releaseTimeout 读取发布超时时间
+func releaseTimeout() time.Duration {
+	return environmentDuration(releaseTimeoutKey, defaultReleaseTimeout)
+}
+
+// deploymentRolloutStatus 计算无状态服务发布状态 (同 kubectl rollout status)
+func deploymentRolloutStatus(deployment *v12.Deployment) (bool, string, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, "Waiting for deployment spec update to be observed", nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == v12.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, "", errors.New(fmt.Sprintf("deployment %s exceeded its progress deadline", deployment.Name))
+		}
+	}
+	var status = deployment.Status
+	if deployment.Spec.Replicas != nil && status.UpdatedReplicas < *deployment.Spec.Replicas {
+		return false, fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, *deployment.Spec.Replicas), nil
+	}
+	if status.Replicas > status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas), nil
+	}
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas), nil
+	}
+	return true, fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas), nil
+}
+
+// statefulSetRolloutStatus 计算有状态服务发布状态 (同 kubectl rollout status, 支持分区发布)
+func statefulSetRolloutStatus(statefulSet *v12.StatefulSet) (bool, string, error) {
+	if statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return false, "Waiting for statefulset spec update to be observed", nil
+	}
+	var status = statefulSet.Status
+	if statefulSet.Spec.Replicas != nil && status.ReadyReplicas < *statefulSet.Spec.Replicas {
+		return false, fmt.Sprintf("%d of %d pods are ready", status.ReadyReplicas, *statefulSet.Spec.Replicas), nil
+	}
+	if statefulSet.Spec.UpdateStrategy.Type == v12.RollingUpdateStatefulSetStrategyType &&
+		statefulSet.Spec.UpdateStrategy.RollingUpdate != nil && statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil &&
+		statefulSet.Spec.Replicas != nil {
+		var partition = *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+		if status.UpdatedReplicas < *statefulSet.Spec.Replicas-partition {
+			return false, fmt.Sprintf("%d of %d pods updated for partitioned roll out (partition: %d)",
+				status.UpdatedReplicas, *statefulSet.Spec.Replicas-partition, partition), nil
+		}
+		return true, fmt.Sprintf("partitioned roll out complete: %d new pods have been updated", status.UpdatedReplicas), nil
+	}
+	if status.UpdateRevision != status.CurrentRevision {
+		return false, fmt.Sprintf("%d pods at revision %s, waiting for update", status.UpdatedReplicas, status.UpdateRevision), nil
+	}
+	return true, fmt.Sprintf("%d pods at revision %s", status.CurrentReplicas, status.CurrentRevision), nil
+}
+
+// daemonSetRolloutStatus 计算守护进程集发布状态 (同 kubectl rollout status)
+func daemonSetRolloutStatus(daemonSet *v12.DaemonSet) (bool, string, error) {
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
+		return false, "Waiting for daemon set spec update to be observed", nil
+	}
+	var status = daemonSet.Status
+	if status.UpdatedNumberScheduled < status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("%d out of %d new pods have been updated", status.UpdatedNumberScheduled, status.DesiredNumberScheduled), nil
+	}
+	if status.NumberAvailable < status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("%d of %d updated pods are available", status.NumberAvailable, status.DesiredNumberScheduled), nil
+	}
+	return true, fmt.Sprintf("%d of %d updated pods are available", status.NumberAvailable, status.DesiredNumberScheduled), nil
+}
+
+// workloadRolloutStatus 计算工作负载发布状态
+func workloadRolloutStatus(workload *Workload) (bool, string, error) {
+	switch item := workload.Object.(type) {
+	case *v12.Deployment:
+		return deploymentRolloutStatus(item)
+	case *v12.StatefulSet:
+		return statefulSetRolloutStatus(item)
+	case *v12.DaemonSet:
+		return daemonSetRolloutStatus(item)
+	}
+	return true, "", nil
+}
+
+// rolloutPods 查询正在发布的 Pod 以及其直接管理者名称 (无状态服务仅查询新版本副本集)
+func (k Kubernetes) rolloutPods(workload *Workload) ([]v1.Pod, []string, error) {
+	deployment, ok := workload.Object.(*v12.Deployment)
+	if !ok {
+		pods, err := k.PodsOf(workload)
+		return pods, nil, err
+	}
+	replicaSet, err := k.newReplicaSet(deployment)
+	if err != nil || replicaSet == nil {
+		return nil, nil, err
+	}
+	pods, err := k.replicaSetPods(replicaSet)
+	return pods, []string{replicaSet.Name}, err
+}
+
+// newReplicaSet 查询无状态服务当前版本的副本集
+func (k Kubernetes) newReplicaSet(deployment *v12.Deployment) (*v12.ReplicaSet, error) {
+	selector, err := labelSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := k.AppsV1().ReplicaSets(deployment.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var revision = deployment.Annotations[deploymentRevisionAnnotation]
+	for i := range list.Items {
+		var item = list.Items[i]
+		if metav1.IsControlledBy(&item, deployment) && item.Annotations[deploymentRevisionAnnotation] == revision {
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+// replicaSetPods 查询副本集下的所有Pod
+func (k Kubernetes) replicaSetPods(replicaSet *v12.ReplicaSet) ([]v1.Pod, error) {
+	selector, err := labelSelector(replicaSet.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list, err := k.CoreV1().Pods(replicaSet.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lo.Filter(list.Items, func(item v1.Pod, _ int) bool {
+		return metav1.IsControlledBy(&item, replicaSet)
+	}), nil
+}
+
+// podsFailure 检查Pod 是否存在无法恢复的容器状态, 返回失败原因
+func podsFailure(pods []v1.Pod) string {
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		var statuses = append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting != nil && lo.Contains(rolloutFailureReasons, status.State.Waiting.Reason) {
+				return fmt.Sprintf("%s/%s %s", pod.Name, status.Name, status.State.Waiting.Reason)
+			}
+		}
+	}
+	return ""
+}
+
+// WaitWorkloadRollout 等待工作负载发布完成, 失败或超时输出诊断信息 (定时任务下次调度生效 无需等待)
+func (k Kubernetes) WaitWorkloadRollout(workload *Workload, timeout time.Duration) error {
+	if workload.Kind == KindCronJob {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Takes Effect On Next Schedule, Skip Waiting", workload))
+		return nil
+	}
+	color.Blue(fmt.Sprintf("[Kubernetes] Waiting Rollout %s (Timeout: %s) ...", workload, timeout))
+	var deadline = time.Now().Add(timeout)
+	for {
+		current, err := k.GetWorkload(workload.Kind, workload.Namespace, workload.Name)
+		if err != nil {
+			return err
+		}
+		done, message, err := workloadRolloutStatus(current)
+		if done {
+			color.Green(fmt.Sprintf("[Kubernetes] %s -> %s Rollout Success: %s", k.colony, workload, message))
+			return nil
+		}
+		pods, owners, podErr := k.rolloutPods(current)
+		if podErr != nil {
+			return podErr
+		}
+		if err == nil {
+			if failure := podsFailure(pods); failure != "" {
+				err = errors.New(failure)
+			} else if time.Now().After(deadline) {
+				err = errors.New(fmt.Sprintf("timeout after %s: %s", timeout, message))
+			}
+		}
+		if err != nil {
+			k.printRolloutDiagnosis(current, owners, pods)
+			return errors.New(fmt.Sprintf("%s -> %s Rollout Fail: %s", k.colony, workload, err))
+		}
+		color.Blue(fmt.Sprintf("[Kubernetes] Waiting Rollout %s: %s", workload, message))
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// printRolloutDiagnosis 输出发布失败的Pod 容器状态以及最近事件
+func (k Kubernetes) printRolloutDiagnosis(workload *Workload, owners []string, pods []v1.Pod) {
+	color.Red(fmt.Sprintf("[Kubernetes] %s -> %s Pods:", k.colony, workload))
+	var rows [][]string
+	for _, pod := range pods {
+		var statuses = append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			rows = append(rows, []string{pod.Name, status.Name, strconv.FormatBool(status.Ready),
+				strconv.Itoa(int(status.RestartCount)), containerState(status.State), containerState(status.LastTerminationState)})
+		}
+	}
+	common.PrintTable([]string{"Pod", "Container", "Ready", "Restarts", "State", "Last State"}, rows)
+
+	// 事件仅保留与工作负载、副本集、Pod 相关的记录
+	var names = append([]string{workload.Name}, owners...)
+	names = append(names, lo.Map(pods, func(item v1.Pod, _ int) string { return item.Name })...)
+	events, err := k.namespaceEvents(workload.Namespace, func(item v1.Event) bool {
+		return lo.Contains(names, item.InvolvedObject.Name)
+	})
+	if err != nil {
+		color.Yellow(fmt.Sprintf("[Kubernetes] List Events Fail: %s", err))
+		return
+	}
+	if len(events) > rolloutEventLimit {
+		events = events[len(events)-rolloutEventLimit:]
+	}
+	color.Red(fmt.Sprintf("[Kubernetes] %s -> %s Events:", k.colony, workload))
+	printEvents(events)
+}
+
+// namespaceEvents 查询命名空间中满足条件的事件 (按发生时间排序)
+func (k Kubernetes) namespaceEvents(namespace string, match func(item v1.Event) bool) ([]v1.Event, error) {
+	list, err := k.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var events = lo.Filter(list.Items, func(item v1.Event, _ int) bool {
+		return match(item)
+	})
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	return events, nil
+}
+
+// printEvents 输出事件表格
+func printEvents(events []v1.Event) {
+	common.PrintTable([]string{"Time", "Type", "Object", "Reason", "Message"}, lo.Map(events, func(item v1.Event, _ int) []string {
+		return []string{eventTime(item).Format("2006-01-02 15:04:05"), item.Type,
+			item.InvolvedObject.Kind + "/" + item.InvolvedObject.Name, item.Reason, item.Message}
+	}))
+}
+
+// containerState 容器状态描述
+func containerState(state v1.ContainerState) string {
+	if state.Waiting != nil {
+		return lo.Ternary(state.Waiting.Message == "", "Waiting: "+state.Waiting.Reason,
+			"Waiting: "+state.Waiting.Reason+" ("+state.Waiting.Message+")")
+	}
+	if state.Running != nil {
+		return "Running"
+	}
+	if state.Terminated != nil {
+		return fmt.Sprintf("Terminated: %s (ExitCode: %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+	}
+	return ""
+}
+
+// eventTime 事件发生时间
+func eventTime(event v1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+	"sync"
+)
+
+// ScaleWorkload 通过 scale 子资源修改工作负载副本数量, 返回修改前的副本数量
+func (k Kubernetes) ScaleWorkload(workload *Workload, replicas int32) (int32, error) {
+	var ctx = context.Background()
+	var scale *autoscalingv1.Scale
+	var err error
+	switch workload.Kind {
+	case KindDeployment:
+		scale, err = k.AppsV1().Deployments(workload.Namespace).GetScale(ctx, workload.Name, metav1.GetOptions{})
+	case KindStatefulSet:
+		scale, err = k.AppsV1().StatefulSets(workload.Namespace).GetScale(ctx, workload.Name, metav1.GetOptions{})
+	default:
+		return 0, errors.New(fmt.Sprintf("%s -> %s Scale Not Support", k.colony, workload))
+	}
+	if err != nil {
+		return 0, err
+	}
+	var previous = scale.Spec.Replicas
+	scale.Spec.Replicas = replicas
+	color.Green(fmt.Sprintf("[Kubernetes] Scale %s Replicas: %d -> %d", workload, previous, replicas))
+	switch workload.Kind {
+	case KindDeployment:
+		_, err = k.AppsV1().Deployments(workload.Namespace).UpdateScale(ctx, workload.Name, scale, metav1.UpdateOptions{})
+	case KindStatefulSet:
+		_, err = k.AppsV1().StatefulSets(workload.Namespace).UpdateScale(ctx, workload.Name, scale, metav1.UpdateOptions{})
+	}
+	return previous, err
+}
+
+// PauseWorkload 暂停或恢复工作负载 (无状态服务修改 spec.paused, 定时任务修改 spec.suspend), 返回修改前的状态
+func (k Kubernetes) PauseWorkload(workload *Workload, paused bool) (bool, error) {
+	var previous bool
+	var field string
+	switch item := workload.Object.(type) {
+	case *v12.Deployment:
+		previous, field = item.Spec.Paused, "paused"
+	case *batchv1.CronJob:
+		previous, field = lo.FromPtr(item.Spec.Suspend), "suspend"
+	default:
+		return false, errors.New(fmt.Sprintf("%s -> %s Pause Not Support", k.colony, workload))
+	}
+	color.Green(fmt.Sprintf("[Kubernetes] %s %s spec.%s: %t -> %t", lo.Ternary(paused, "Pause", "Resume"), workload, field, previous, paused))
+	requestByteData, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{field: paused},
+	})
+	if err != nil {
+		return false, err
+	}
+	_, err = k.PatchWorkload(workload, types.MergePatchType, requestByteData)
+	return previous, err
+}
+
+// executeWorkloadChange 对全部 集群 x 命名空间 的服务执行修改, 输出修改前后对比与执行结果
+func executeWorkloadChange(colony, env, namespace, serviceName, field string, f func(client *Kubernetes, workload *Workload) (string, string, error)) error {
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	var lock sync.Mutex
+	var rows [][]string
+	err = eachTarget(colony, namespace, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		workload, err := kubernetesClient.GetWorkload(kind, namespace, serviceName)
+		if err != nil {
+			return err
+		}
+		before, after, err := f(kubernetesClient, workload)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		rows = append(rows, []string{colony, namespace, workload.String(), field, before, after})
+		lock.Unlock()
+		return nil
+	})
+	common.PrintTable([]string{"集群", "命名空间", "工作负载", "字段", "修改前", "修改后"}, rows)
+	return err
+}
+
+// ExecuteScaleService 执行修改服务副本数量.
+func ExecuteScaleService(colony, env, namespace, serviceName string, replicas int32) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 副本数量: %d", colony, env, namespace, serviceName, replicas))
+	return executeWorkloadChange(colony, env, namespace, serviceName, "replicas", func(client *Kubernetes, workload *Workload) (string, string, error) {
+		previous, err := client.ScaleWorkload(workload, replicas)
+		return strconv.Itoa(int(previous)), strconv.Itoa(int(replicas)), err
+	})
+}
+
+// ExecutePauseService 执行暂停 (paused 为 false 时恢复) 服务.
+func ExecutePauseService(colony, env, namespace, serviceName string, paused bool) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s %s", colony, env, namespace, serviceName,
+		lo.Ternary(paused, "暂停", "恢复")))
+	return executeWorkloadChange(colony, env, namespace, serviceName, "paused", func(client *Kubernetes, workload *Workload) (string, string, error) {
+		previous, err := client.PauseWorkload(workload, paused)
+		return strconv.FormatBool(previous), strconv.FormatBool(paused), err
+	})
+}
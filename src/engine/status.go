@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WorkloadStatus 工作负载实时状态
+type WorkloadStatus struct {
+	Colony    string `json:"colony"`    // 集群名称
+	Namespace string `json:"namespace"` // 命名空间
+	Workload  string `json:"workload"`  // 工作负载
+	Images    string `json:"images"`    // 容器镜像
+	Ready     int32  `json:"ready"`     // 就绪副本数量
+	Desired   int32  `json:"desired"`   // 期望副本数量
+	Rollout   string `json:"rollout"`   // 发布状态
+	Restarts  int32  `json:"restarts"`  // Pod 重启次数
+	Age       string `json:"age"`       // 创建时长
+	Message   string `json:"message"`   // 说明 (查询失败或跳过原因)
+}
+
+// workloadReplicas 工作负载 就绪 / 期望 副本数量 (定时任务不计算副本数量)
+func workloadReplicas(workload *Workload) (int32, int32) {
+	switch item := workload.Object.(type) {
+	case *v12.Deployment:
+		return item.Status.ReadyReplicas, lo.FromPtr(item.Spec.Replicas)
+	case *v12.StatefulSet:
+		return item.Status.ReadyReplicas, lo.FromPtr(item.Spec.Replicas)
+	case *v12.DaemonSet:
+		return item.Status.NumberReady, item.Status.DesiredNumberScheduled
+	}
+	return 0, 0
+}
+
+// WorkloadStatus 查询工作负载实时状态
+func (k Kubernetes) WorkloadStatus(kind, namespace, name string) (*WorkloadStatus, error) {
+	workload, err := k.GetWorkload(kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	var status = &WorkloadStatus{
+		Colony:    k.colony,
+		Namespace: namespace,
+		Workload:  workload.String(),
+		Images:    templateImages(workload.Template),
+		Age:       duration.HumanDuration(time.Since(workload.Object.GetCreationTimestamp().Time)),
+	}
+	status.Ready, status.Desired = workloadReplicas(workload)
+	done, message, err := workloadRolloutStatus(workload)
+	switch {
+	case err != nil:
+		status.Rollout = "Failed: " + err.Error()
+	case done:
+		status.Rollout = "Complete"
+	default:
+		status.Rollout = "Progressing: " + message
+	}
+	pods, err := k.PodsOf(workload)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		for _, item := range pod.Status.ContainerStatuses {
+			status.Restarts += item.RestartCount
+		}
+	}
+	return status, nil
+}
+
+// ExecuteServiceStatus 查询服务在全部 集群 x 命名空间 的实时状态, 以表格 (或 JSON) 输出
+func ExecuteServiceStatus(colony, env, namespace, serviceName string, jsonOutput bool) error {
+	kind, err := workloadKind()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		// JSON 输出时诊断信息 (跳过 / 失败提示) 写入标准错误, 保持标准输出为合法 JSON
+		var output = color.Output
+		color.Output = color.Error
+		defer func() {
+			color.Output = output
+		}()
+	} else {
+		color.Blue(fmt.Sprintf("[Kubernetes] 集群: %s 环境: %s 命名空间: %s 服务名称: %s 实时状态", colony, env, namespace, serviceName))
+	}
+	var lock sync.Mutex
+	var statuses = map[string]*WorkloadStatus{}
+	var results = runTargets(colony, namespace, releaseParallelism(), false, func(colony, namespace string) error {
+		kubernetesClient, err := targetClient(colony, env, namespace)
+		if err != nil {
+			return err
+		}
+		status, err := kubernetesClient.WorkloadStatus(kind, namespace, serviceName)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		statuses[colony+"/"+namespace] = status
+		lock.Unlock()
+		return nil
+	})
+	var list []WorkloadStatus
+	for _, item := range results {
+		if status, ok := statuses[item.Colony+"/"+item.Namespace]; ok {
+			list = append(list, *status)
+			continue
+		}
+		list = append(list, WorkloadStatus{Colony: item.Colony, Namespace: item.Namespace, Rollout: item.Status, Message: item.Message})
+	}
+	if jsonOutput {
+		value, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(value))
+		return nil
+	}
+	var rows [][]string
+	for index, item := range list {
+		rows = append(rows, []string{strconv.Itoa(index + 1), item.Colony, item.Namespace, item.Workload, item.Images,
+			fmt.Sprintf("%d/%d", item.Ready, item.Desired), item.Rollout, strconv.Itoa(int(item.Restarts)), item.Age, item.Message})
+	}
+	common.PrintTable([]string{"序号", "集群", "命名空间", "工作负载", "镜像", "就绪", "发布状态", "重启次数", "创建时长", "说明"}, rows)
+	return nil
+}
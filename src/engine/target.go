@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/common"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 并发执行的目标数量 环境变量
+const releaseParallelismKey = "P_RELEASE_PARALLELISM"
+
+// 默认并发执行的目标数量
+const defaultReleaseParallelism = 4
+
+// 失败策略 环境变量 (continue: 继续执行剩余目标 / failfast: 停止执行剩余目标)
+const releaseFailurePolicyKey = "P_RELEASE_FAILURE_POLICY"
+
+// 失败策略
+const (
+	failurePolicyContinue = "continue"
+	failurePolicyFailFast = "failfast"
+)
+
+// 目标执行结果
+const (
+	TargetSuccess  = "Success"  // 成功
+	TargetSkipped  = "Skipped"  // 跳过 (命名空间不存在)
+	TargetFailed   = "Failed"   // 失败
+	TargetCanceled = "Canceled" // 未执行 (failfast 策略下已有目标失败)
+)
+
+// TargetResult 集群 x 命名空间 执行结果
+type TargetResult struct {
+	Colony    string        // 集群名称
+	Namespace string        // 命名空间
+	Status    string        // 执行结果
+	Message   string        // 说明
+	Duration  time.Duration // 耗时
+}
+
+// skipError 跳过目标 (不视为失败)
+type skipError struct {
+	message string
+}
+
+func (e skipError) Error() string {
+	return e.message
+}
+
+// releaseParallelism 读取并发执行的目标数量
+func releaseParallelism() int {
+	value, ok := environment.Get(releaseParallelismKey)
+	if !ok {
+		return defaultReleaseParallelism
+	}
+	parallelism, err := strconv.Atoi(value)
+	if err != nil || parallelism <= 0 {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s=%s 格式错误, 使用默认值 %d", releaseParallelismKey, value, defaultReleaseParallelism))
+		return defaultReleaseParallelism
+	}
+	return parallelism
+}
+
+// releaseFailFast 是否在目标失败后停止执行剩余目标
+func releaseFailFast() bool {
+	value, _ := environment.Get(releaseFailurePolicyKey)
+	return strings.ToLower(value) == failurePolicyFailFast
+}
+
+// runTargets 并发对 集群 x 命名空间 执行操作, 返回每个目标的执行结果
+func runTargets(colony, namespace string, parallelism int, failFast bool, f func(colony, namespace string) error) []TargetResult {
+	var results []TargetResult
+	for _, c := range strings.Split(colony, ",") {
+		for _, n := range strings.Split(namespace, ",") {
+			results = append(results, TargetResult{Colony: strings.ToUpper(c), Namespace: n, Status: TargetCanceled})
+		}
+	}
+	var waitGroup sync.WaitGroup
+	var lock sync.Mutex
+	var failed bool
+	var semaphore = make(chan struct{}, parallelism)
+	for i := range results {
+		semaphore <- struct{}{}
+		lock.Lock()
+		var stop = failFast && failed
+		lock.Unlock()
+		if stop {
+			<-semaphore
+			break
+		}
+		waitGroup.Add(1)
+		go func(result *TargetResult) {
+			defer func() {
+				<-semaphore
+				waitGroup.Done()
+			}()
+			var start = time.Now()
+			var err = f(result.Colony, result.Namespace)
+			result.Duration = time.Since(start)
+			var skip skipError
+			switch {
+			case err == nil:
+				result.Status = TargetSuccess
+			case errors.As(err, &skip):
+				result.Status = TargetSkipped
+				result.Message = skip.message
+			default:
+				result.Status = TargetFailed
+				result.Message = err.Error()
+				color.Red(fmt.Sprintf("[Kubernetes] %s / %s Fail: %s", result.Colony, result.Namespace, err))
+				lock.Lock()
+				failed = true
+				lock.Unlock()
+			}
+		}(&results[i])
+	}
+	waitGroup.Wait()
+	return results
+}
+
+// printTargetResults 输出执行结果汇总
+func printTargetResults(results []TargetResult) {
+	var rows [][]string
+	for index, item := range results {
+		rows = append(rows, []string{strconv.Itoa(index + 1), item.Colony, item.Namespace, item.Status,
+			item.Duration.Round(time.Second).String(), item.Message})
+	}
+	common.PrintTable([]string{"序号", "集群", "命名空间", "结果", "耗时", "说明"}, rows)
+}
+
+// eachTarget 按并发数量与失败策略对 集群 x 命名空间 执行操作, 输出汇总并在存在失败目标时返回错误
+func eachTarget(colony, namespace string, f func(colony, namespace string) error) error {
+	results := runTargets(colony, namespace, releaseParallelism(), releaseFailFast(), f)
+	printTargetResults(results)
+	var failed = lo.CountBy(results, func(item TargetResult) bool {
+		return item.Status == TargetFailed || item.Status == TargetCanceled
+	})
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("[Kubernetes] %d of %d targets failed or canceled", failed, len(results)))
+	}
+	return nil
+}
@@ -6,22 +6,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/nuwa/bpp.v3/environment"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"io"
-	"io/fs"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// 腾讯云Nacos 配置列表每页数量
+const tencentNacosPageSize = 300
+
 type TencentNacos struct {
 	host        string // 域名.
 	username    string // Nacos 用户名称.
@@ -146,19 +143,30 @@ func NewTencent(host string, username string, password string) (*TencentNacos, e
 	return tencent, nil
 }
 
-// GetNacosConfigList 读取Nacos 配置
+// GetNacosConfigList 读取Nacos 配置 (逐页读取直到达到总数)
 func (tencent *TencentNacos) GetNacosConfigList(namespaceId string) (*[]TencentNacosConfigItem, error) {
-	var urlPath = fmt.Sprintf("/nacos/v1/cs/configs?dataId=&group=&appName=&config_tags=&pageNo=1&pageSize=300&tenant=%s&search=accurate&accessToken=%s&username=nacos", namespaceId, tencent.accessToken)
-	var response struct {
-		TotalCount int                      `json:"totalCount"`
-		PageNumber int                      `json:"pageNumber"`
-		PageItems  []TencentNacosConfigItem `json:"pageItems"`
-	}
-	err := tencent.Get(urlPath, &response)
+	var items = make([]TencentNacosConfigItem, 0)
+	var pageNo = 1
+	err := recursion(func() (bool, error) {
+		var urlPath = fmt.Sprintf("/nacos/v1/cs/configs?dataId=&group=&appName=&config_tags=&pageNo=%d&pageSize=%d&tenant=%s&search=accurate&accessToken=%s&username=nacos",
+			pageNo, tencentNacosPageSize, namespaceId, tencent.accessToken)
+		var response struct {
+			TotalCount int                      `json:"totalCount"`
+			PageNumber int                      `json:"pageNumber"`
+			PageItems  []TencentNacosConfigItem `json:"pageItems"`
+		}
+		err := tencent.Get(urlPath, &response)
+		if err != nil {
+			return false, err
+		}
+		items = append(items, response.PageItems...)
+		pageNo++
+		return len(response.PageItems) > 0 && len(items) < response.TotalCount, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &response.PageItems, nil
+	return &items, nil
 }
 
 // GetNacosConfig 获取Nacos配置详情.
@@ -237,138 +245,61 @@ func (tencent *TencentNacos) CreateNacosConfig(namespaceId string, group string,
 	return nil
 }
 
-// Sync 同步标签.
-func (tencent *TencentNacos) Sync(namespaceId string, rootPath string) error {
-	log.Println(fmt.Sprintf("Sync Nacos Config By NamespaceId: %s", namespaceId))
-	// 读取本地磁盘
-	var files []string
-	var pathSeparator = string(os.PathSeparator)
-	rootPath = strings.ReplaceAll(rootPath, "\\", pathSeparator)
-	rootPath = strings.ReplaceAll(rootPath, "/", pathSeparator)
-	err := filepath.Walk(rootPath, func(path string, info fs.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		var relativePath = strings.TrimPrefix(path, rootPath)
-		files = append(files, strings.TrimPrefix(strings.TrimPrefix(relativePath, "/"), "\\"))
-		return nil
-	})
+// Namespaces 获取命名空间列表.
+func (tencent *TencentNacos) Namespaces() ([]NacosNamespace, error) {
+	var urlPath = fmt.Sprintf("/nacos/v1/console/namespaces?accessToken=%s", tencent.accessToken)
+	var response struct {
+		Data []TencentNacosNamespace `json:"data"`
+	}
+	err := tencent.Get(urlPath, &response)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 读取线上配置
+	return lo.Map(response.Data, func(item TencentNacosNamespace, _ int) NacosNamespace {
+		return NacosNamespace{Id: item.Namespace, Name: item.NamespaceShowName, ConfigCount: item.ConfigCount}
+	}), nil
+}
+
+// ListConfigs 获取配置列表.
+func (tencent *TencentNacos) ListConfigs(namespaceId string) ([]NacosConfig, error) {
 	configList, err := tencent.GetNacosConfigList(namespaceId)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return lo.Map(*configList, func(item TencentNacosConfigItem, _ int) NacosConfig {
+		return NacosConfig{Group: item.Group, DataId: item.DataId, Content: item.Content, Type: item.Type}
+	}), nil
+}
 
-	// 是否存在新增
-	var addTask []string
-	var addTaskConfig []string
-	for i := range files {
-		_, result := lo.Find(*configList, func(item TencentNacosConfigItem) bool {
-			return strings.TrimSuffix(files[i], path.Ext(files[i])) == item.DataId
-		})
-		if !result {
-			addTask = append(addTask, files[i])
-			fileByte, err := os.ReadFile(path.Join(rootPath, files[i]))
-			if err != nil {
-				return err
-			}
-			addTaskConfig = append(addTaskConfig, string(fileByte))
-		}
+// GetConfig 获取配置详情.
+func (tencent *TencentNacos) GetConfig(namespaceId string, group string, dataId string) (*NacosConfig, error) {
+	config, err := tencent.GetNacosConfig(namespaceId, group, dataId)
+	if err != nil {
+		return nil, err
 	}
+	return &NacosConfig{Group: config.Group, DataId: config.DataId, Content: config.Content, Type: config.Type}, nil
+}
 
-	// 是否存在修改
-	var updateTask []string
-	var updateConfig []string
-	var updateConfigId []string
-	for i := range *configList {
-		filePath, result := lo.Find(files, func(item string) bool {
-			return strings.TrimSuffix(item, path.Ext(item)) == (*configList)[i].DataId
-		})
-		if !result {
-			continue
-		}
-		var item = (*configList)[i]
-		config, err := tencent.GetNacosConfig(namespaceId, item.Group, item.DataId)
-		if err != nil {
-			return err
-		}
-		fileByte, err := os.ReadFile(path.Join(rootPath, filePath))
-		if err != nil {
-			return err
-		}
-		var fileContent = string(fileByte)
-		if config.Content == fileContent && config.Type == strings.TrimPrefix(path.Ext(filePath), ".") {
-			continue
-		}
-		updateTask = append(updateTask, filePath)
-		updateConfig = append(updateConfig, fileContent)
-		updateConfigId = append(updateConfigId, config.DataId)
-	}
+// CreateConfig 创建配置.
+func (tencent *TencentNacos) CreateConfig(namespaceId string, config NacosConfig) error {
+	return tencent.CreateNacosConfig(namespaceId, config.Group, config.DataId, config.Content, config.Type)
+}
 
-	// 是否存在删除
-	var deleteTask []TencentNacosConfigItem
-	for i := range *configList {
-		_, result := lo.Find(files, func(item string) bool {
-			return strings.TrimSuffix(item, path.Ext(item)) == (*configList)[i].DataId
-		})
-		if !result {
-			deleteTask = append(deleteTask, (*configList)[i])
-		}
-	}
+// UpdateConfig 修改配置.
+func (tencent *TencentNacos) UpdateConfig(namespaceId string, config NacosConfig) error {
+	return tencent.UpdateNacosConfig(namespaceId, config.Group, config.DataId, config.Content, config.Type)
+}
 
-	log.Println(fmt.Sprintf("Create: %d条", len(addTask)))
-	lo.ForEach(addTask, func(it string, i int) {
-		err = tencent.CreateNacosConfig(namespaceId, "DEFAULT_GROUP",
-			strings.TrimSuffix(it, path.Ext(it)), addTaskConfig[i], strings.TrimPrefix(path.Ext(it), "."))
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	log.Println(fmt.Sprintf("Update: %d条", len(updateTask)))
-	lo.ForEach(updateTask, func(it string, i int) {
-		err := tencent.UpdateNacosConfig(namespaceId, "DEFAULT_GROUP", updateConfigId[i], updateConfig[i], path.Ext(it))
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	log.Println(fmt.Sprintf("Delete: %d条", len(deleteTask)))
-	lo.ForEach(deleteTask, func(it TencentNacosConfigItem, _ int) {
-		err := tencent.DeleteNacosConfig(namespaceId, it.Group, it.DataId)
-		if err != nil {
-			log.Fatalf("Error:%s", err)
-		}
-	})
-	return nil
+// DeleteConfig 删除配置.
+func (tencent *TencentNacos) DeleteConfig(namespaceId string, group string, dataId string) error {
+	return tencent.DeleteNacosConfig(namespaceId, group, dataId)
 }
 
-// TencentNacosSync 腾讯云Nacos 同步.
-func TencentNacosSync(instanceKey, instanceNamespace, nacosDirectory string) error {
-	var key = configNacosKey + instanceKey
-	instanceJson, ok := environment.Get(key)
-	if !ok {
-		return errors.New(fmt.Sprintf("Run Sync Nacos Not Find Param: %s", key))
-	}
-	instanceConfigMap := make(map[string]string)
-	err := json.Unmarshal([]byte(instanceJson), &instanceConfigMap)
-	if err != nil {
-		return err
-	}
-	host, hostOk := instanceConfigMap["host"]
-	username, usernameOk := instanceConfigMap["username"]
-	password, passwordOk := instanceConfigMap["password"]
-	if !hostOk || !usernameOk || !passwordOk {
-		return errors.New("Tencent Config Json Param Error")
-	}
-	tencent, err := NewTencent(host, username, password)
-	if err != nil {
-		return err
-	}
-	err = tencent.Sync(instanceNamespace, nacosDirectory)
+// newTencentNacosProvider 通过实例配置 (host / username / password) 创建腾讯云Nacos 客户端.
+func newTencentNacosProvider(config map[string]string) (NacosProvider, error) {
+	values, err := nacosConfigParams(nacosServiceTencent, config, "host", "username", "password")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return NewTencent(values[0], values[1], values[2])
 }
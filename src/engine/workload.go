@@ -0,0 +1,394 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nuwa/bpp.v3/environment"
+	"github.com/samber/lo"
+	v12 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 工作负载类型 环境变量 (为空时按名称自动识别)
+const workloadKindKey = "P_WORKLOAD_KIND"
+
+// 工作负载类型
+const (
+	KindDeployment  = "Deployment"  // 无状态服务
+	KindStatefulSet = "StatefulSet" // 有状态服务
+	KindDaemonSet   = "DaemonSet"   // 守护进程集
+	KindCronJob     = "CronJob"     // 定时任务
+)
+
+// 重启注解 (同 kubectl rollout restart)
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// 工作负载类型 自动识别顺序
+var workloadKinds = []string{KindDeployment, KindStatefulSet, KindDaemonSet, KindCronJob}
+
+// 工作负载类型 别名
+var workloadKindAlias = map[string]string{
+	"deployment":  KindDeployment,
+	"deploy":      KindDeployment,
+	"statefulset": KindStatefulSet,
+	"sts":         KindStatefulSet,
+	"daemonset":   KindDaemonSet,
+	"ds":          KindDaemonSet,
+	"cronjob":     KindCronJob,
+	"cj":          KindCronJob,
+}
+
+// Workload 工作负载 (无状态服务、有状态服务、守护进程集、定时任务)
+type Workload struct {
+	Kind         string                // 类型
+	Namespace    string                // 命名空间
+	Name         string                // 名称
+	Object       metav1.Object         // 原始对象
+	Template     *v1.PodTemplateSpec   // Pod 模板
+	Selector     *metav1.LabelSelector // Pod 选择器 (定时任务为空)
+	templatePath string                // Pod 模板 JSON Patch 路径
+}
+
+// String 工作负载描述
+func (w *Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// onDelete 是否为删除 Pod 后才生效的更新策略
+func (w *Workload) onDelete() bool {
+	switch item := w.Object.(type) {
+	case *v12.StatefulSet:
+		return item.Spec.UpdateStrategy.Type == v12.OnDeleteStatefulSetStrategyType
+	case *v12.DaemonSet:
+		return item.Spec.UpdateStrategy.Type == v12.OnDeleteDaemonSetStrategyType
+	}
+	return false
+}
+
+// partition 有状态服务滚动更新分区, 序号小于分区的 Pod 不会更新
+func (w *Workload) partition() int32 {
+	if item, ok := w.Object.(*v12.StatefulSet); ok && item.Spec.UpdateStrategy.RollingUpdate != nil &&
+		item.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+		return *item.Spec.UpdateStrategy.RollingUpdate.Partition
+	}
+	return 0
+}
+
+// workloadKind 读取工作负载类型, 为空时自动识别
+func workloadKind() (string, error) {
+	value, ok := environment.Get(workloadKindKey)
+	if !ok {
+		return "", nil
+	}
+	kind, ok := workloadKindAlias[strings.ToLower(value)]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Environment variable ${%s}=%s not support (Deployment / StatefulSet / DaemonSet / CronJob)",
+			workloadKindKey, value))
+	}
+	return kind, nil
+}
+
+// GetWorkload 查询工作负载, kind 为空时按 Deployment StatefulSet DaemonSet CronJob 顺序识别
+func (k Kubernetes) GetWorkload(kind, namespace, name string) (*Workload, error) {
+	for _, item := range lo.Ternary(kind == "", workloadKinds, []string{kind}) {
+		workload, err := k.getWorkload(item, namespace, name)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return workload, nil
+	}
+	return nil, errors.New(fmt.Sprintf("%s -> %s/%s Service Not", k.colony, namespace, name))
+}
+
+// getWorkload 按类型查询工作负载
+func (k Kubernetes) getWorkload(kind, namespace, name string) (*Workload, error) {
+	var ctx = context.Background()
+	var object metav1.Object
+	var err error
+	switch kind {
+	case KindDeployment:
+		object, err = k.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	case KindStatefulSet:
+		object, err = k.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case KindDaemonSet:
+		object, err = k.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case KindCronJob:
+		object, err = k.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	default:
+		err = errors.New(fmt.Sprintf("Workload Kind %s Not Support", kind))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWorkload(object), nil
+}
+
+// newWorkload 由原始对象创建工作负载
+func newWorkload(object metav1.Object) *Workload {
+	var workload = &Workload{Namespace: object.GetNamespace(), Name: object.GetName(), Object: object, templatePath: "/spec/template"}
+	switch item := object.(type) {
+	case *v12.Deployment:
+		workload.Kind, workload.Template, workload.Selector = KindDeployment, &item.Spec.Template, item.Spec.Selector
+	case *v12.StatefulSet:
+		workload.Kind, workload.Template, workload.Selector = KindStatefulSet, &item.Spec.Template, item.Spec.Selector
+	case *v12.DaemonSet:
+		workload.Kind, workload.Template, workload.Selector = KindDaemonSet, &item.Spec.Template, item.Spec.Selector
+	case *batchv1.CronJob:
+		workload.Kind, workload.Template = KindCronJob, &item.Spec.JobTemplate.Spec.Template
+		workload.templatePath = "/spec/jobTemplate/spec/template"
+	}
+	return workload
+}
+
+// patchOptions 修改选项 (试运行模式下由服务端校验 不会持久化)
+func (k Kubernetes) patchOptions() metav1.PatchOptions {
+	if k.dryRun {
+		return metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.PatchOptions{}
+}
+
+// PatchWorkload 修改工作负载, 返回修改后的工作负载
+func (k Kubernetes) PatchWorkload(workload *Workload, patchType types.PatchType, data []byte) (*Workload, error) {
+	var ctx = context.Background()
+	var object metav1.Object
+	var err error
+	switch workload.Kind {
+	case KindDeployment:
+		object, err = k.AppsV1().Deployments(workload.Namespace).Patch(ctx, workload.Name, patchType, data, k.patchOptions())
+	case KindStatefulSet:
+		object, err = k.AppsV1().StatefulSets(workload.Namespace).Patch(ctx, workload.Name, patchType, data, k.patchOptions())
+	case KindDaemonSet:
+		object, err = k.AppsV1().DaemonSets(workload.Namespace).Patch(ctx, workload.Name, patchType, data, k.patchOptions())
+	case KindCronJob:
+		object, err = k.BatchV1().CronJobs(workload.Namespace).Patch(ctx, workload.Name, patchType, data, k.patchOptions())
+	default:
+		err = errors.New(fmt.Sprintf("Workload Kind %s Not Support", workload.Kind))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWorkload(object), nil
+}
+
+// patchWorkloadTemplate 以 JSON Patch 修改 Pod 模板, operations 为相对 Pod 模板的路径与值
+func (k Kubernetes) patchWorkloadTemplate(workload *Workload, operations ...map[string]interface{}) (*Workload, error) {
+	for _, item := range operations {
+		item["path"] = workload.templatePath + fmt.Sprint(item["path"])
+	}
+	requestByteData, err := json.Marshal(operations)
+	if err != nil {
+		return nil, err
+	}
+	return k.PatchWorkload(workload, types.JSONPatchType, requestByteData)
+}
+
+// containerOperations 生成修改目标容器字段的 JSON Patch (先校验容器名称 防止下标错位)
+func containerOperations(containers []ContainerTarget, field string, value interface{}) []map[string]interface{} {
+	var operations []map[string]interface{}
+	for _, container := range containers {
+		operations = append(operations, map[string]interface{}{
+			"op": "test", "path": container.path() + "/name", "value": container.Name,
+		}, map[string]interface{}{
+			"op": "replace", "path": container.path() + "/" + field, "value": value,
+		})
+	}
+	return operations
+}
+
+// restartOperation 生成修改 Pod 模板重启注解的 JSON Patch
+func restartOperation(workload *Workload) map[string]interface{} {
+	return templateAnnotationOperation(workload, restartedAtAnnotation, time.Now().Format(time.RFC3339))
+}
+
+// templateAnnotationOperation 生成添加 Pod 模板注解的 JSON Patch (注解不存在时添加注解, 元数据为空时整体添加)
+func templateAnnotationOperation(workload *Workload, key, value string) map[string]interface{} {
+	if workload.Template.Annotations != nil {
+		return map[string]interface{}{"op": "add", "value": value,
+			"path": "/metadata/annotations/" + strings.ReplaceAll(key, "/", "~1")}
+	}
+	if !reflect.DeepEqual(workload.Template.ObjectMeta, metav1.ObjectMeta{}) {
+		return map[string]interface{}{"op": "add", "path": "/metadata/annotations",
+			"value": map[string]string{key: value}}
+	}
+	return map[string]interface{}{"op": "add", "path": "/metadata",
+		"value": map[string]interface{}{"annotations": map[string]string{key: value}}}
+}
+
+// RestoreWorkloadTemplate 恢复工作负载的 Pod 模板
+func (k Kubernetes) RestoreWorkloadTemplate(workload *Workload, template *v1.PodTemplateSpec) (bool, error) {
+	color.Green(fmt.Sprintf("[Kubernetes] Restore %s Template : %s/%s <- %s", workload.Kind, workload.Namespace, workload.Name, templateImages(template)))
+	_, err := k.patchWorkloadTemplate(workload, map[string]interface{}{"op": "replace", "path": "", "value": template})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RestartWorkload 重启工作负载 (同 kubectl rollout restart)
+//   - Deployment / StatefulSet / DaemonSet: 修改 Pod 模板重启注解 按更新策略滚动重启 (有状态服务仅重启分区内的 Pod)
+//   - CronJob: 修改任务模板重启注解 下次调度生效
+func (k Kubernetes) RestartWorkload(workload *Workload) error {
+	color.Blue(fmt.Sprintf("[Kubernetes] Restart %s ...", workload))
+	if workload.partition() > 0 {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Partition: %d, Only Ordinal >= %d Pods Will Be Restarted",
+			workload, workload.partition(), workload.partition()))
+	}
+	if workload.Kind == KindCronJob {
+		color.Yellow(fmt.Sprintf("[Kubernetes] %s Restart Takes Effect On Next Schedule", workload))
+	}
+	_, err := k.patchWorkloadTemplate(workload, restartOperation(workload))
+	return err
+}
+
+// labelSelector 将工作负载选择器转换为标签选择器字符串
+func labelSelector(selector *metav1.LabelSelector) (string, error) {
+	result, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// PodsOf 查询工作负载的全部 Pod (通过工作负载自身的选择器, 并校验 Pod 的管理者)
+//   - Deployment: 由其副本集管理的 Pod
+//   - StatefulSet / DaemonSet: 直接管理的 Pod
+//   - CronJob: 由其任务管理的 Pod
+func (k Kubernetes) PodsOf(workload *Workload) ([]v1.Pod, error) {
+	var ctx = context.Background()
+	var owners = []metav1.Object{workload.Object}
+	var selectors []*metav1.LabelSelector
+	switch workload.Kind {
+	case KindDeployment:
+		selector, err := labelSelector(workload.Selector)
+		if err != nil {
+			return nil, err
+		}
+		list, err := k.AppsV1().ReplicaSets(workload.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		owners = nil
+		for i := range list.Items {
+			if metav1.IsControlledBy(&list.Items[i], workload.Object) {
+				owners = append(owners, &list.Items[i])
+			}
+		}
+		selectors = []*metav1.LabelSelector{workload.Selector}
+	case KindCronJob:
+		list, err := k.BatchV1().Jobs(workload.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		owners = nil
+		for i := range list.Items {
+			if metav1.IsControlledBy(&list.Items[i], workload.Object) {
+				owners = append(owners, &list.Items[i])
+				selectors = append(selectors, list.Items[i].Spec.Selector)
+			}
+		}
+	default:
+		selectors = []*metav1.LabelSelector{workload.Selector}
+	}
+
+	var pods []v1.Pod
+	for _, item := range selectors {
+		selector, err := labelSelector(item)
+		if err != nil {
+			return nil, err
+		}
+		list, err := k.CoreV1().Pods(workload.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, lo.Filter(list.Items, func(pod v1.Pod, _ int) bool {
+			return lo.ContainsBy(owners, func(owner metav1.Object) bool { return metav1.IsControlledBy(&pod, owner) })
+		})...)
+	}
+	return lo.UniqBy(pods, func(item v1.Pod) types.UID { return item.UID }), nil
+}
+
+// recreateWorkloadPods OnDelete 策略下逐个删除 Pod 使新模板生效 (有状态服务按序号倒序)
+func (k Kubernetes) recreateWorkloadPods(workload *Workload, timeout time.Duration) error {
+	pods, err := k.PodsOf(workload)
+	if err != nil {
+		return err
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return podOrdinal(pods[i].Name) > podOrdinal(pods[j].Name)
+	})
+	for _, pod := range pods {
+		_, err := k.DeletePod(workload.Namespace, pod.Name)
+		if err != nil {
+			return err
+		}
+		err = k.waitWorkloadReady(workload, pod.UID, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitWorkloadReady 等待被删除的 Pod 消失且工作负载全部就绪
+func (k Kubernetes) waitWorkloadReady(workload *Workload, deletedUID types.UID, timeout time.Duration) error {
+	var deadline = time.Now().Add(timeout)
+	for {
+		current, err := k.GetWorkload(workload.Kind, workload.Namespace, workload.Name)
+		if err != nil {
+			return err
+		}
+		pods, err := k.PodsOf(current)
+		if err != nil {
+			return err
+		}
+		_, deleting := lo.Find(pods, func(item v1.Pod) bool { return item.UID == deletedUID })
+		if !deleting && workloadReady(current) {
+			return nil
+		}
+		if failure := podsFailure(pods); failure != "" {
+			return errors.New(fmt.Sprintf("%s -> %s Recreate Pod Fail: %s", k.colony, workload, failure))
+		}
+		if time.Now().After(deadline) {
+			return errors.New(fmt.Sprintf("%s -> %s Recreate Pod Timeout after %s", k.colony, workload, timeout))
+		}
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// workloadReady 工作负载的 Pod 是否全部就绪
+func workloadReady(workload *Workload) bool {
+	switch item := workload.Object.(type) {
+	case *v12.StatefulSet:
+		return item.Spec.Replicas == nil || item.Status.ReadyReplicas >= *item.Spec.Replicas
+	case *v12.DaemonSet:
+		return item.Status.NumberReady >= item.Status.DesiredNumberScheduled
+	case *v12.Deployment:
+		return item.Spec.Replicas == nil || item.Status.ReadyReplicas >= *item.Spec.Replicas
+	}
+	return true
+}
+
+// podOrdinal 有状态服务 Pod 序号 (名称最后一段数字)
+func podOrdinal(podName string) int {
+	ordinal, err := strconv.Atoi(podName[strings.LastIndex(podName, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
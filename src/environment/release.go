@@ -0,0 +1,79 @@
+package environment
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+// ReleaseRecord 发布记录.
+type ReleaseRecord struct {
+	Service       string `json:"service"`       // 服务名称
+	Colony        string `json:"colony"`        // 集群名称
+	Env           string `json:"env"`           // 集群环境
+	Namespace     string `json:"namespace"`     // 命名空间
+	Strategy      string `json:"strategy"`      // 发布策略
+	CommitSha     string `json:"commitSha"`     // 提交版本
+	Author        string `json:"author"`        // 提交作者
+	PreviousImage string `json:"previousImage"` // 发布前镜像
+	Image         string `json:"image"`         // 发布镜像
+	Result        string `json:"result"`        // 发布结果
+	Message       string `json:"message"`       // 说明
+	Duration      int64  `json:"duration"`      // 耗时 (毫秒)
+	Time          string `json:"time"`          // 发布时间
+}
+
+// SaveRelease 保存发布记录到服务器.
+func SaveRelease(record ReleaseRecord) error {
+	response, err := post("/release/save", map[string]interface{}{
+		"service":       record.Service,
+		"colony":        record.Colony,
+		"env":           record.Env,
+		"namespace":     record.Namespace,
+		"strategy":      record.Strategy,
+		"commitSha":     record.CommitSha,
+		"author":        record.Author,
+		"previousImage": record.PreviousImage,
+		"image":         record.Image,
+		"result":        record.Result,
+		"message":       record.Message,
+		"duration":      record.Duration,
+		"time":          record.Time,
+	})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return errors.New(result.Message)
+	}
+	return nil
+}
+
+// ListRelease 查询服务的发布记录 (按时间倒序).
+func ListRelease(service string) ([]ReleaseRecord, error) {
+	response, err := get("/release/list/" + url.PathEscape(service))
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Success bool            `json:"success"`
+		Message string          `json:"message"`
+		Data    []ReleaseRecord `json:"data"`
+	}
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, errors.New(result.Message)
+	}
+	return result.Data, nil
+}
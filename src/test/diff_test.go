@@ -0,0 +1,39 @@
+package test
+
+import (
+	"github.com/nuwa/bpp.v3/common"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	var from = "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	var to = "a\nb\nc\nD\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+	var expected = `--- nacos/a
++++ local/a
+@@ -1,7 +1,7 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+\ No newline at end of file
+`
+	if result := common.UnifiedDiff("nacos/a", "local/a", from, to, 3); result != expected {
+		t.Errorf("diff:\n%s", result)
+	}
+	if result := common.UnifiedDiff("nacos/a", "local/a", from, from, 3); result != "" {
+		t.Errorf("same content diff: %s", result)
+	}
+	var expectedCreate = "--- /dev/null\n+++ local/b\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if result := common.UnifiedDiff("/dev/null", "local/b", "", "x\ny\n", 3); result != expectedCreate {
+		t.Errorf("create diff:\n%s", result)
+	}
+}
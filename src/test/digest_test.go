@@ -0,0 +1,106 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nuwa/bpp.v3/engine"
+	"github.com/nuwa/bpp.v3/environment"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry 本地测试镜像仓库 (Bearer 认证, 令牌服务校验用户名与密码)
+func newTestRegistry(t *testing.T, digest string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/token":
+			username, password, ok := request.BasicAuth()
+			if !ok || username != "nuwa" || password != "secret" || request.URL.Query().Get("scope") != "repository:nuwa/demo:pull" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(writer).Encode(map[string]string{"token": "test-token"})
+		case request.URL.Path == "/v2/nuwa/demo/manifests/1.0.0":
+			if request.Header.Get("Authorization") != "Bearer test-token" {
+				writer.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:nuwa/demo:pull"`, server.URL))
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !strings.Contains(request.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				t.Errorf("Accept: %s", request.Header.Get("Accept"))
+			}
+			writer.Header().Set("Docker-Content-Digest", digest)
+			_, _ = writer.Write([]byte("{}"))
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	var digest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	var server = newTestRegistry(t, digest)
+	defer server.Close()
+	var registry = strings.TrimPrefix(server.URL, "http://")
+
+	result, err := engine.ResolveImageDigest(registry+"/nuwa/demo:1.0.0", &engine.RegistryAuth{Registry: registry, Username: "nuwa", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != digest {
+		t.Errorf("%s != %s", result, digest)
+	}
+
+	// 错误的密码无法获取令牌
+	_, err = engine.ResolveImageDigest(registry+"/nuwa/demo:1.0.0", &engine.RegistryAuth{Registry: registry, Username: "nuwa", Password: "wrong"})
+	if err == nil {
+		t.Error("expected auth error")
+	}
+
+	// 不存在的版本号
+	_, err = engine.ResolveImageDigest(registry+"/nuwa/demo:2.0.0", nil)
+	if err == nil {
+		t.Error("expected not found error")
+	}
+}
+
+func TestImageDigestsResolve(t *testing.T) {
+	var digest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	var server = newTestRegistry(t, digest)
+	defer server.Close()
+	var registry = strings.TrimPrefix(server.URL, "http://")
+	// 改写版本号后按改写结果解析摘要 (仓库只存在 1.0.0), 改写规则已固定摘要时不再查询
+	environment.Put("GL_IMAGE_NAME_CONFIG", `{
+		"ACK": [{"type": "tag", "tag": "1.0.0"}],
+		"TKE": [{"type": "digest", "digest": "sha256:0000"}]
+	}`)
+	defer environment.Put("GL_IMAGE_NAME_CONFIG", "")
+
+	var digests = engine.NewImageDigests(&engine.RegistryAuth{Registry: registry, Username: "nuwa", Password: "secret"})
+	result, err := digests.Resolve("ACK", "prod", registry+"/nuwa/demo:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != registry+"/nuwa/demo@"+digest {
+		t.Errorf("tag rule: %s", result)
+	}
+	result, err = digests.Resolve("TKE", "prod", registry+"/nuwa/demo:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != registry+"/nuwa/demo@sha256:0000" {
+		t.Errorf("digest rule: %s", result)
+	}
+	// 未开启固定摘要时仅改写
+	result, err = (*engine.ImageDigests)(nil).Resolve("ACK", "prod", registry+"/nuwa/demo:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != registry+"/nuwa/demo:1.0.0" {
+		t.Errorf("without digest: %s", result)
+	}
+}
@@ -0,0 +1,46 @@
+package test
+
+import (
+	"github.com/nuwa/bpp.v3/engine"
+	"github.com/nuwa/bpp.v3/environment"
+	"testing"
+)
+
+func TestResolveImageName(t *testing.T) {
+	environment.Put("GL_IMAGE_NAME_CONFIG", `{
+		"tke": "registry.cn-shanghai.aliyuncs.com->ccr.ccs.tencentyun.com",
+		"ACK": [
+			{"type": "registry", "from": "registry.cn-shanghai.aliyuncs.com", "to": "registry-vpc.cn-shanghai.aliyuncs.com"},
+			{"type": "repository", "from": "nuwa", "to": "nuwa-prod"},
+			{"type": "tag", "env": "prod", "tag": "stable"},
+			{"type": "regex", "pattern": "-vpc\\.cn-shanghai", "replace": "-vpc.cn-hangzhou"}
+		]
+	}`)
+	var imageName = "registry.cn-shanghai.aliyuncs.com/nuwa/demo:1.0.0"
+	for _, item := range []struct {
+		colony, env, expected string
+	}{
+		{"TKE", "test", "ccr.ccs.tencentyun.com/nuwa/demo:1.0.0"},
+		{"ACK", "test", "registry-vpc.cn-hangzhou.aliyuncs.com/nuwa-prod/demo:1.0.0"},
+		{"ACK", "prod", "registry-vpc.cn-hangzhou.aliyuncs.com/nuwa-prod/demo:stable"},
+		{"OTHER", "prod", imageName},
+	} {
+		result, _, err := engine.ResolveImageName(item.colony, item.env, imageName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != item.expected {
+			t.Errorf("%s/%s: %s != %s", item.colony, item.env, result, item.expected)
+		}
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	var reference = engine.ParseImageReference("localhost:5000/nuwa/demo:1.0.0@sha256:abc")
+	if reference.Registry != "localhost:5000" || reference.Repository != "nuwa/demo" || reference.Tag != "1.0.0" || reference.Digest != "sha256:abc" {
+		t.Errorf("%+v", reference)
+	}
+	if reference.String() != "localhost:5000/nuwa/demo@sha256:abc" {
+		t.Errorf("%s", reference)
+	}
+}
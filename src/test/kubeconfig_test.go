@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/nuwa/bpp.v3/engine"
+	"github.com/nuwa/bpp.v3/environment"
+	"k8s.io/client-go/rest"
+	"testing"
+)
+
+// testKubeconfig 包含两个上下文的 kubeconfig (current-context 为 dev)
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: admin
+  user:
+    token: admin-token
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+`
+
+func TestRestConfig(t *testing.T) {
+	// 令牌认证 (CA 证书支持 Base64)
+	var ca = "-----BEGIN CERTIFICATE-----\ntest\n-----END CERTIFICATE-----\n"
+	config, err := engine.RestConfig("TEST", "dev", `{"mode": "token", "server": "https://api.example.com", "token": "t", "certificateAuthorityData": "`+
+		base64.StdEncoding.EncodeToString([]byte(ca))+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://api.example.com" || config.BearerToken != "t" || string(config.TLSClientConfig.CAData) != ca {
+		t.Errorf("token: %+v", config)
+	}
+	_, err = engine.RestConfig("TEST", "dev", `{"mode": "token", "server": "https://api.example.com"}`)
+	if err == nil {
+		t.Error("expected empty token error")
+	}
+
+	// 跳过证书校验时忽略 CA 证书
+	config, err = engine.RestConfig("TEST", "dev", `{"mode": "token", "server": "https://api.example.com", "token": "t", "insecure": true, "certificateAuthorityData": "ca"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.TLSClientConfig.Insecure || len(config.TLSClientConfig.CAData) != 0 {
+		t.Errorf("insecure: %+v", config.TLSClientConfig)
+	}
+	if _, err = rest.HTTPClientFor(config); err != nil {
+		t.Errorf("insecure client: %s", err)
+	}
+
+	// 外部命令认证
+	config, err = engine.RestConfig("TEST", "dev", `{"mode": "exec", "server": "https://api.example.com", "command": "aws", "args": ["eks", "get-token"], "env": {"AWS_PROFILE": "dev"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ExecProvider == nil || config.ExecProvider.Command != "aws" || len(config.ExecProvider.Args) != 2 ||
+		config.ExecProvider.APIVersion != "client.authentication.k8s.io/v1beta1" || len(config.ExecProvider.Env) != 1 {
+		t.Errorf("exec: %+v", config.ExecProvider)
+	}
+
+	// 集群内认证 (非集群内运行时返回 ErrNotInCluster)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err = engine.RestConfig("TEST", "dev", `{"mode": "inCluster"}`)
+	if !errors.Is(err, rest.ErrNotInCluster) {
+		t.Errorf("inCluster: %v", err)
+	}
+
+	// 不支持的认证方式
+	_, err = engine.RestConfig("TEST", "dev", `{"mode": "password", "server": "https://api.example.com"}`)
+	if err == nil {
+		t.Error("expected mode error")
+	}
+
+	// kubeconfig 默认使用 current-context, 按 集群_环境 选择上下文
+	config, err = engine.RestConfig("TEST", "dev", testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://dev.example.com" {
+		t.Errorf("current context: %s", config.Host)
+	}
+	environment.Put("P_KUBERNETES_CONTEXT_TEST_PROD", "prod")
+	defer environment.Put("P_KUBERNETES_CONTEXT_TEST_PROD", "")
+	config, err = engine.RestConfig("TEST", "prod", testKubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://prod.example.com" || config.BearerToken != "admin-token" {
+		t.Errorf("prod context: %+v", config)
+	}
+	environment.Put("P_KUBERNETES_CONTEXT_TEST_PROD", "staging")
+	_, err = engine.RestConfig("TEST", "prod", testKubeconfig)
+	if err == nil {
+		t.Error("expected context not found error")
+	}
+}
@@ -0,0 +1,244 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nuwa/bpp.v3/engine"
+	"github.com/samber/lo"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testNacos 本地测试开源 Nacos (v2 Open API, 上下文路径 /custom, 第一个令牌在读取配置列表后过期)
+type testNacos struct {
+	lock    sync.Mutex
+	logins  int
+	expired bool
+	configs map[string]map[string]string // group/dataId -> content / type
+}
+
+func (n *testNacos) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	var success = func(data any) {
+		_ = json.NewEncoder(writer).Encode(map[string]any{"code": 0, "message": "success", "data": data})
+	}
+	if request.URL.Path == "/custom/v1/auth/login" {
+		if request.FormValue("username") != "nacos" || request.FormValue("password") != "secret" {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		n.logins++
+		_ = json.NewEncoder(writer).Encode(map[string]any{"accessToken": fmt.Sprintf("token-%d", n.logins), "tokenTtl": 18000})
+		return
+	}
+	var token = request.URL.Query().Get("accessToken")
+	if token != fmt.Sprintf("token-%d", n.logins) || (n.expired && token == "token-1") {
+		writer.WriteHeader(http.StatusForbidden)
+		_, _ = writer.Write([]byte("token expired!"))
+		return
+	}
+	if request.FormValue("group") != "" {
+		// AK/SK 签名
+		var mac = hmac.New(sha1.New, []byte("sk"))
+		mac.Write([]byte("dev+" + request.FormValue("group") + "+" + request.Header.Get("Timestamp")))
+		if request.Header.Get("Spas-AccessKey") != "ak" || request.Header.Get("Spas-Signature") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	var key = request.FormValue("group") + "/" + request.FormValue("dataId")
+	switch request.Method + " " + request.URL.Path {
+	case "GET /custom/v2/console/namespace/list":
+		success([]map[string]any{{"namespace": "dev", "namespaceShowName": "dev", "configCount": len(n.configs)}})
+	case "GET /custom/v2/cs/history/configs":
+		var items []map[string]string
+		for itemKey, item := range n.configs {
+			var group, dataId, _ = strings.Cut(itemKey, "/")
+			items = append(items, map[string]string{"group": group, "dataId": dataId, "content": item["content"], "type": item["type"]})
+		}
+		n.expired = true
+		success(items)
+	case "GET /custom/v2/cs/config":
+		success(n.configs[key]["content"])
+	case "POST /custom/v2/cs/config":
+		n.configs[key] = map[string]string{"content": request.FormValue("content"), "type": request.FormValue("type")}
+		success(true)
+	case "DELETE /custom/v2/cs/config":
+		delete(n.configs, key)
+		success(true)
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOpenNacosSync(t *testing.T) {
+	var nacos = &testNacos{configs: map[string]map[string]string{
+		"DEFAULT_GROUP/application": {"content": "port: 8080", "type": "yaml"},
+		"DEFAULT_GROUP/removed":     {"content": "a=1", "type": "properties"},
+		"DEFAULT_GROUP/shared-db":   {"content": "url: db", "type": "yaml"},
+		"SHARED_GROUP/redis":        {"content": "host: old", "type": "yaml"},
+	}}
+	var server = httptest.NewServer(nacos)
+	defer server.Close()
+
+	// 第一级目录为分组 (通过映射文件 shared -> SHARED_GROUP)
+	var directory = t.TempDir()
+	if err := os.Mkdir(filepath.Join(directory, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"application.yaml": "port: 8081", "redis.properties": "host=redis",
+		"shared/redis.yaml": "host: redis", ".nacos-group.json": `{"shared": "SHARED_GROUP"}`} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider, err := engine.NewOpenNacos(engine.OpenNacosOptions{
+		Host:        strings.TrimPrefix(server.URL, "http://"),
+		ContextPath: "/custom",
+		Username:    "nacos",
+		Password:    "secret",
+		AccessKey:   "ak",
+		SecretKey:   "sk",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同步计划不修改线上配置
+	err = engine.SyncNacos(provider, "dev", directory, engine.NacosSyncOptions{Plan: true, Prune: true})
+	if !errors.Is(err, engine.ErrNacosPlanChanges) {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(nacos.configs) != 4 || nacos.configs["DEFAULT_GROUP/application"]["content"] != "port: 8080" {
+		t.Fatalf("plan changed configs: %v", nacos.configs)
+	}
+
+	// 同步计划超过删除上限时仍输出差异并返回 ErrNacosPlanChanges
+	err = engine.SyncNacos(provider, "dev", directory, engine.NacosSyncOptions{Plan: true, Prune: true, MaxDelete: 1})
+	if !errors.Is(err, engine.ErrNacosPlanChanges) || !strings.Contains(err.Error(), "P_NACOS_MAX_DELETE") {
+		t.Fatalf("plan max delete: %v", err)
+	}
+
+	// 删除数量超过上限时终止同步
+	err = engine.SyncNacos(provider, "dev", directory, engine.NacosSyncOptions{Prune: true, MaxDelete: 1})
+	if err == nil || len(nacos.configs) != 4 {
+		t.Fatalf("max delete: %v %v", err, nacos.configs)
+	}
+
+	// 未配置备份目录时拒绝删除
+	err = engine.SyncNacos(provider, "dev", directory, engine.NacosSyncOptions{Prune: true})
+	if err == nil || len(nacos.configs) != 4 {
+		t.Fatalf("no backup: %v %v", err, nacos.configs)
+	}
+
+	var backup = t.TempDir()
+	err = engine.SyncNacos(provider, "dev", directory, engine.NacosSyncOptions{Prune: true, MaxDelete: 1, Protect: []string{"shared-*"}, BackupDirectory: backup})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 令牌过期后重新登录
+	if nacos.logins != 2 {
+		t.Errorf("logins: %d", nacos.logins)
+	}
+	if nacos.configs["DEFAULT_GROUP/application"]["content"] != "port: 8081" {
+		t.Errorf("application: %v", nacos.configs["DEFAULT_GROUP/application"])
+	}
+	if nacos.configs["DEFAULT_GROUP/redis"]["type"] != "properties" {
+		t.Errorf("redis: %v", nacos.configs["DEFAULT_GROUP/redis"])
+	}
+	if nacos.configs["SHARED_GROUP/redis"]["content"] != "host: redis" {
+		t.Errorf("shared redis: %v", nacos.configs["SHARED_GROUP/redis"])
+	}
+	if _, ok := nacos.configs["DEFAULT_GROUP/removed"]; ok {
+		t.Error("removed config not deleted")
+	}
+	if _, ok := nacos.configs["DEFAULT_GROUP/shared-db"]; !ok {
+		t.Error("protected config deleted")
+	}
+	// 删除前备份
+	backupFiles, _ := filepath.Glob(filepath.Join(backup, "dev", "*", "DEFAULT_GROUP", "removed.properties"))
+	if len(backupFiles) != 1 {
+		t.Errorf("backup: %v", backupFiles)
+	} else if content, _ := os.ReadFile(backupFiles[0]); string(content) != "a=1" {
+		t.Errorf("backup content: %s", content)
+	}
+
+	// 错误的密码无法登录
+	_, err = engine.NewOpenNacos(engine.OpenNacosOptions{Host: server.URL, ContextPath: "/custom", Username: "nacos", Password: "wrong"})
+	if err == nil {
+		t.Error("expected login error")
+	}
+}
+
+// testNacosPages 测试分页读取的配置列表 (服务端每页最多返回 2 条)
+func testNacosPages(total, page int) (items []map[string]string) {
+	for index := (page - 1) * 2; index < total && index < page*2; index++ {
+		items = append(items, map[string]string{"group": "DEFAULT_GROUP", "dataId": fmt.Sprintf("config-%d", index)})
+	}
+	return items
+}
+
+func TestTencentNacosPaging(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/nacos/v1/auth/users/login":
+			_ = json.NewEncoder(writer).Encode(map[string]string{"accessToken": "token"})
+		case "/nacos/v1/cs/configs":
+			page, _ := strconv.Atoi(request.URL.Query().Get("pageNo"))
+			_ = json.NewEncoder(writer).Encode(map[string]any{"totalCount": 5, "pageNumber": page, "pageItems": testNacosPages(5, page)})
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	provider, err := engine.NewTencent(server.URL, "nacos", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := provider.ListConfigs("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 5 || configs[4].DataId != "config-4" {
+		t.Errorf("configs: %v", configs)
+	}
+}
+
+func TestAliyunNacosPaging(t *testing.T) {
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.FormValue("Action") != "ListNacosConfigs" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page, _ := strconv.Atoi(request.FormValue("PageNum"))
+		var configurations = lo.Map(testNacosPages(5, page), func(item map[string]string, _ int) map[string]string {
+			return map[string]string{"Group": item["group"], "DataId": item["dataId"]}
+		})
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]any{"Success": true, "TotalCount": 5, "PageNumber": page, "Configurations": configurations})
+	}))
+	defer server.Close()
+	provider, err := engine.NewAliyunNacosEndpoint(server.URL, "ak", "sk", "mse-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := provider.ListConfigs("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 5 || configs[4].DataId != "config-4" {
+		t.Errorf("configs: %v", configs)
+	}
+}